@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/openyard/eventstore/pkg/genproto/grpcapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func appendCmd(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("append", flag.ExitOnError)
+	file := fs.String("f", "-", "JSON or NDJSON file with append requests, - reads stdin")
+	_ = fs.Parse(args)
+
+	requests, err := readRequests(*file, func() *grpcapi.AppendRequest { return &grpcapi.AppendRequest{} })
+	if err != nil {
+		return err
+	}
+	var streams, events int
+	for _, req := range requests {
+		if _, err := c.store.Append(ctx, req); err != nil {
+			return err
+		}
+		streams += len(req.StreamData)
+		for _, sd := range req.StreamData {
+			events += len(sd.Events)
+		}
+	}
+	return c.out.Summary(fmt.Sprintf("appended %d events to %d streams", events, streams),
+		map[string]int{"streams": streams, "events": events})
+}
+
+func readCmd(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("read", flag.ExitOnError)
+	file := fs.String("f", "", "JSON or NDJSON file with read requests instead of stream arguments")
+	from := fs.Uint64("from", 0, "stream position to start reading at")
+	maxCount := fs.Uint("max", 0, "max count of events per stream, 0 reads all events")
+	backward := fs.Bool("backward", false, "read backward, from 0 starts at the last event")
+	_ = fs.Parse(args)
+
+	requests := []*grpcapi.ReadRequest{{
+		Streams:   fs.Args(),
+		From:      *from,
+		MaxCount:  uint32(*maxCount),
+		Direction: direction(*backward),
+	}}
+	if *file != "" {
+		var err error
+		if requests, err = readRequests(*file, func() *grpcapi.ReadRequest { return &grpcapi.ReadRequest{} }); err != nil {
+			return err
+		}
+	}
+	for _, req := range requests {
+		streams, err := c.store.Read(ctx, req)
+		if err != nil {
+			return err
+		}
+		if err := c.out.Streams(streams); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readAtCmd(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("read-at", flag.ExitOnError)
+	file := fs.String("f", "", "JSON or NDJSON file with read-at requests instead of stream arguments")
+	at := fs.String("at", "", "point in time in RFC3339 format (default now)")
+	_ = fs.Parse(args)
+
+	ts := time.Now()
+	if *at != "" {
+		var err error
+		if ts, err = time.Parse(time.RFC3339Nano, *at); err != nil {
+			return err
+		}
+	}
+	requests := []*grpcapi.ReadAtRequest{{At: timestamppb.New(ts), Streams: fs.Args()}}
+	if *file != "" {
+		var err error
+		if requests, err = readRequests(*file, func() *grpcapi.ReadAtRequest { return &grpcapi.ReadAtRequest{} }); err != nil {
+			return err
+		}
+	}
+	for _, req := range requests {
+		streams, err := c.store.ReadAt(ctx, req)
+		if err != nil {
+			return err
+		}
+		if err := c.out.Streams(streams); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteCmd(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	hard := fs.Bool("hard", false, "tombstone the streams, so they can't be recreated")
+	expected := fs.Int64("expected-version", -1, "version the streams must be at, -1 deletes them at any version")
+	_ = fs.Parse(args)
+
+	mode := grpcapi.ExpectedVersionMode_Exact
+	if *expected < 0 {
+		// soft deleted streams don't exist anymore, but can still be deleted hard
+		mode = grpcapi.ExpectedVersionMode_Any
+	}
+	for _, name := range fs.Args() {
+		req := &grpcapi.DeleteStreamRequest{Name: name, ExpectedVersion: uint64(max(*expected, 0)), ExpectedVersionMode: mode, Hard: *hard}
+		if _, err := c.store.DeleteStream(ctx, req); err != nil {
+			return err
+		}
+	}
+	return c.out.Summary(fmt.Sprintf("deleted %d streams", fs.NArg()), map[string]any{"streams": fs.Args(), "hard": *hard})
+}
+
+func metadataCmd(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("metadata", flag.ExitOnError)
+	set := fs.Bool("set", false, "replace the metadata instead of printing it")
+	maxCount := fs.Uint64("max-count", 0, "retain the last events only, 0 retains all events")
+	maxAge := fs.Duration("max-age", 0, "retain the events occurred within the duration only, 0 retains all events")
+	truncateBefore := fs.Uint64("truncate-before", 0, "retain the events from the stream position on, 0 retains all events")
+	expected := fs.Int64("expected-version", -1, "version the metadata must be at, -1 replaces it at any version")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("exactly one stream expected")
+	}
+	if *set {
+		metadata := &grpcapi.StreamMetadata{Name: fs.Arg(0), MaxCount: *maxCount, TruncateBefore: *truncateBefore}
+		if *maxAge > 0 {
+			metadata.MaxAge = durationpb.New(*maxAge)
+		}
+		mode := grpcapi.ExpectedVersionMode_Exact
+		if *expected < 0 {
+			mode = grpcapi.ExpectedVersionMode_Any
+		}
+		req := &grpcapi.SetStreamMetadataRequest{Metadata: metadata, ExpectedVersion: uint64(max(*expected, 0)), ExpectedVersionMode: mode}
+		if _, err := c.store.SetStreamMetadata(ctx, req); err != nil {
+			return err
+		}
+	}
+	m, err := c.store.GetStreamMetadata(ctx, &grpcapi.GetStreamMetadataRequest{Name: fs.Arg(0)})
+	if err != nil {
+		return err
+	}
+	return c.out.Summary(fmt.Sprintf("stream %s: metadata version %d, max count %d, max age %s, truncate before %d",
+		m.Name, m.Version, m.MaxCount, m.MaxAge.AsDuration(), m.TruncateBefore),
+		map[string]any{"stream": m.Name, "version": m.Version, "maxCount": m.MaxCount,
+			"maxAge": m.MaxAge.AsDuration().String(), "truncateBefore": m.TruncateBefore})
+}
+
+func schemaCmd(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	file := fs.String("register", "", "register the schema definition in the file as next version, - reads stdin")
+	format := fs.String("format", "json-schema", "format of the definition: json-schema or protobuf (FileDescriptorSet)")
+	message := fs.String("message", "", "full name of the message validating protobuf payloads")
+	expected := fs.Int64("expected-version", -1, "version the schema must be at, -1 registers it at any version")
+	_ = fs.Parse(args)
+
+	if fs.NArg() > 1 || (*file != "" && fs.NArg() != 1) {
+		return errors.New("one event name expected")
+	}
+	if *file != "" {
+		definition, err := readFile(*file)
+		if err != nil {
+			return err
+		}
+		schema := &grpcapi.Schema{EventName: fs.Arg(0), Definition: definition, MessageName: *message}
+		switch *format {
+		case "json-schema":
+			schema.Format = grpcapi.SchemaFormat_JSONSchema
+		case "protobuf":
+			schema.Format = grpcapi.SchemaFormat_Protobuf
+		default:
+			return fmt.Errorf("unknown schema format: %s", *format)
+		}
+		mode := grpcapi.ExpectedVersionMode_Exact
+		if *expected < 0 {
+			mode = grpcapi.ExpectedVersionMode_Any
+		}
+		req := &grpcapi.RegisterSchemaRequest{Schema: schema, ExpectedVersion: uint64(max(*expected, 0)), ExpectedVersionMode: mode}
+		if _, err := c.schemas.RegisterSchema(ctx, req); err != nil {
+			return err
+		}
+	}
+	res, err := c.schemas.ListSchemas(ctx, &grpcapi.ListSchemasRequest{EventName: fs.Arg(0)})
+	if err != nil {
+		return err
+	}
+	for _, s := range res.Schemas {
+		text := fmt.Sprintf("event %s: schema version %d (%s, %d bytes) registered at %s", s.EventName, s.Version,
+			s.Format, len(s.Definition), s.RegisteredAt.AsTime().Format(time.RFC3339))
+		if err := c.out.Summary(text, map[string]any{"eventName": s.EventName, "version": s.Version,
+			"format": s.Format.String(), "messageName": s.MessageName, "registeredAt": s.RegisteredAt.AsTime()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entriesReceiver is implemented by the streams of all subscriptions
+type entriesReceiver interface {
+	Recv() (*grpcapi.Entries, error)
+}
+
+func subscribeCmd(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("subscribe", flag.ExitOnError)
+	offset := fs.Uint64("offset", 0, "global position to catch up from, 0 only delivers new entries")
+	id := fs.String("id", "", "id of a persistent subscription resuming after its last acknowledged entry")
+	limit := fs.Uint("limit", 0, "max count of entries per batch")
+	ack := fs.Bool("ack", true, "acknowledge received entries of a persistent subscription")
+	_ = fs.Parse(args)
+
+	var stream entriesReceiver
+	var err error
+	switch {
+	case *id != "":
+		stream, err = c.transport.SubscribeWithID(ctx, &grpcapi.SubscriptionWithIDRequest{SubscriptionID: *id, Limit: uint32(*limit)})
+	case *offset > 0:
+		stream, err = c.transport.SubscribeWithOffset(ctx, &grpcapi.SubscriptionWithOffsetRequest{Offset: *offset, Limit: uint32(*limit)})
+	default:
+		stream, err = c.transport.Subscribe(ctx, &grpcapi.SubscriptionRequest{Limit: uint32(*limit)})
+	}
+	if err != nil {
+		return err
+	}
+	for {
+		entries, err := stream.Recv()
+		if errors.Is(err, io.EOF) || status.Code(err) == codes.Canceled {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.out.Entries(entries); err != nil {
+			return err
+		}
+		if *id != "" && *ack && len(entries.Entries) > 0 {
+			last := entries.Entries[len(entries.Entries)-1].GlobalPos
+			if _, err := c.transport.Ack(ctx, &grpcapi.AckRequest{SubscriptionID: *id, Position: last}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func statsCmd(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	last, err := c.store.ReadAll(ctx, &grpcapi.ReadAllRequest{MaxCount: 1, Direction: grpcapi.Direction_Backward})
+	if err != nil {
+		return err
+	}
+	var position uint64
+	if len(last.Entries) > 0 {
+		position = last.Entries[0].GlobalPos
+	}
+	text := fmt.Sprintf("global position: %d", position)
+	versions := make(map[string]uint64)
+	if fs.NArg() > 0 {
+		streams, err := c.store.Read(ctx, &grpcapi.ReadRequest{Streams: fs.Args(), MaxCount: 1, Direction: grpcapi.Direction_Backward})
+		if err != nil {
+			return err
+		}
+		for _, s := range streams.Streams {
+			versions[s.Name] = s.Version
+			text += fmt.Sprintf("\nstream %s: version %d", s.Name, s.Version)
+		}
+	}
+	return c.out.Summary(text, map[string]any{"globalPosition": position, "streams": versions})
+}
+
+func scavengeCmd(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("scavenge", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	stream, err := c.admin.Scavenge(ctx, &grpcapi.ScavengeRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		p, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		text := fmt.Sprintf("scavenged %d/%d: %d events removed, %d bytes freed", p.Position, p.Head, p.RemovedEvents, p.FreedBytes)
+		if p.Done {
+			text += fmt.Sprintf(", %d bytes compacted", p.CompactedBytes)
+		}
+		if err := c.out.Summary(text, map[string]any{"position": p.Position, "head": p.Head, "removedEvents": p.RemovedEvents,
+			"freedBytes": p.FreedBytes, "compactedBytes": p.CompactedBytes, "done": p.Done}); err != nil {
+			return err
+		}
+	}
+}
+
+func listCmd(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "list the streams whose names start with the prefix only")
+	pageSize := fs.Uint("page-size", 0, "max count of streams per request, 0 requests the default count")
+	token := fs.String("token", "", "continuation token of a previous listing")
+	all := fs.Bool("all", true, "request pages until all streams are listed")
+	_ = fs.Parse(args)
+
+	req := &grpcapi.ListStreamsRequest{Prefix: *prefix, ContinuationToken: *token, PageSize: uint32(*pageSize)}
+	for {
+		res, err := c.store.ListStreams(ctx, req)
+		if err != nil {
+			return err
+		}
+		for _, s := range res.Streams {
+			text := fmt.Sprintf("stream %s: version %d", s.Name, s.Version)
+			v := map[string]any{"stream": s.Name, "version": s.Version}
+			if s.FirstEventAt != nil {
+				text += fmt.Sprintf(", events from %s to %s", s.FirstEventAt.AsTime().Format(time.RFC3339Nano),
+					s.LastEventAt.AsTime().Format(time.RFC3339Nano))
+				v["firstEventAt"], v["lastEventAt"] = s.FirstEventAt.AsTime(), s.LastEventAt.AsTime()
+			}
+			if err := c.out.Summary(text, v); err != nil {
+				return err
+			}
+		}
+		if res.ContinuationToken == "" {
+			return nil
+		}
+		if !*all {
+			return c.out.Summary("continuation token: "+res.ContinuationToken, map[string]any{"continuationToken": res.ContinuationToken})
+		}
+		req.ContinuationToken = res.ContinuationToken
+	}
+}
+
+// readFile returns the content of the file, - reads stdin
+func readFile(file string) ([]byte, error) {
+	if file == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(file)
+}
+
+// readRequests reads one JSON request or one request per line (NDJSON) from the file, - reads stdin
+func readRequests[T proto.Message](file string, newRequest func() T) ([]T, error) {
+	raw, err := readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	req := newRequest()
+	if err := protojson.Unmarshal(raw, req); err == nil {
+		return []T{req}, nil
+	}
+	requests := make([]T, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(raw)+1)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		req := newRequest()
+		if err := protojson.Unmarshal(scanner.Bytes(), req); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		requests = append(requests, req)
+	}
+	return requests, scanner.Err()
+}
+
+func direction(backward bool) grpcapi.Direction {
+	if backward {
+		return grpcapi.Direction_Backward
+	}
+	return grpcapi.Direction_Forward
+}
+
+func shredCmd(ctx context.Context, c *client, args []string) error {
+	fs := flag.NewFlagSet("shred", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	for _, id := range fs.Args() {
+		if _, err := c.admin.ShredAggregate(ctx, &grpcapi.ShredAggregateRequest{AggregateID: id}); err != nil {
+			return err
+		}
+	}
+	return c.out.Summary(fmt.Sprintf("shredded %d aggregates", fs.NArg()), map[string]any{"aggregates": fs.Args()})
+}
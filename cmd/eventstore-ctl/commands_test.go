@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openyard/eventstore/pkg/genproto/grpcapi"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestReadRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []*grpcapi.ReadRequest
+		wantErr string
+	}{
+		{
+			name:    "single object",
+			content: "{\n  \"Streams\": [\"stream-1\", \"stream-2\"],\n  \"MaxCount\": 10\n}\n",
+			want:    []*grpcapi.ReadRequest{{Streams: []string{"stream-1", "stream-2"}, MaxCount: 10}},
+		},
+		{
+			name:    "ndjson",
+			content: "{\"Streams\": [\"stream-1\"]}\n{\"Streams\": [\"stream-2\"], \"Direction\": \"Backward\"}\n",
+			want: []*grpcapi.ReadRequest{
+				{Streams: []string{"stream-1"}},
+				{Streams: []string{"stream-2"}, Direction: grpcapi.Direction_Backward},
+			},
+		},
+		{
+			name:    "blank lines",
+			content: "\n{\"Streams\": [\"stream-1\"]}\n  \n\n{\"Streams\": [\"stream-2\"]}\n\n",
+			want:    []*grpcapi.ReadRequest{{Streams: []string{"stream-1"}}, {Streams: []string{"stream-2"}}},
+		},
+		{
+			name:    "empty",
+			content: "\n",
+			want:    []*grpcapi.ReadRequest{},
+		},
+		{
+			name:    "invalid json",
+			content: "{\"Streams\": [\"stream-1\"]}\n{\"Streams\": \n",
+			wantErr: ":2: ",
+		},
+		{
+			name:    "unknown field",
+			content: "{\"Streams\": [\"stream-1\"]}\n{\"Stream\": \"stream-2\"}\n",
+			wantErr: ":2: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "requests.json")
+			if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readRequests(file, func() *grpcapi.ReadRequest { return &grpcapi.ReadRequest{} })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), file+tt.wantErr) {
+					t.Fatalf("readRequests() error = %v, want error containing %q", err, file+tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readRequests() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("readRequests() got %d requests, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !proto.Equal(got[i], tt.want[i]) {
+					t.Errorf("readRequests() request %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadRequests_MissingFile(t *testing.T) {
+	_, err := readRequests(filepath.Join(t.TempDir(), "missing.json"),
+		func() *grpcapi.ReadRequest { return &grpcapi.ReadRequest{} })
+	if !os.IsNotExist(err) {
+		t.Errorf("readRequests() error = %v, want not exist", err)
+	}
+}
+
+// deletingStore records the delete requests
+type deletingStore struct {
+	grpcapi.EventStoreClient
+	requests []*grpcapi.DeleteStreamRequest
+}
+
+func (d *deletingStore) DeleteStream(_ context.Context, in *grpcapi.DeleteStreamRequest, _ ...grpc.CallOption) (*grpcapi.Empty, error) {
+	d.requests = append(d.requests, in)
+	return &grpcapi.Empty{}, nil
+}
+
+func TestDeleteCmd(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want *grpcapi.DeleteStreamRequest
+	}{
+		{"any version", []string{"stream-1"},
+			&grpcapi.DeleteStreamRequest{Name: "stream-1", ExpectedVersionMode: grpcapi.ExpectedVersionMode_Any}},
+		{"hard at any version", []string{"-hard", "stream-1"},
+			&grpcapi.DeleteStreamRequest{Name: "stream-1", ExpectedVersionMode: grpcapi.ExpectedVersionMode_Any, Hard: true}},
+		{"exact version", []string{"-expected-version", "3", "stream-1"},
+			&grpcapi.DeleteStreamRequest{Name: "stream-1", ExpectedVersion: 3, ExpectedVersionMode: grpcapi.ExpectedVersionMode_Exact}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &deletingStore{}
+			out, err := newPrinter("text", io.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := deleteCmd(context.Background(), &client{store: store, out: out}, tt.args); err != nil {
+				t.Fatalf("deleteCmd() error = %v", err)
+			}
+			if len(store.requests) != 1 || !proto.Equal(store.requests[0], tt.want) {
+				t.Errorf("deleteCmd() requests = %v, want %v", store.requests, tt.want)
+			}
+		})
+	}
+}
@@ -1,5 +1,124 @@
 package main
 
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/openyard/eventstore/pkg/genproto/grpcapi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var (
+	version  = "TRUNK"
+	revision = "HEAD"
+)
+
+// command of the cli, run with the arguments following the command name
+type command struct {
+	usage string
+	run   func(ctx context.Context, c *client, args []string) error
+}
+
+var commands = map[string]command{
+	"append":    {"append stream data from a JSON or NDJSON file (default stdin)", appendCmd},
+	"read":      {"read streams, optionally paged", readCmd},
+	"read-at":   {"read streams as they were at a point in time", readAtCmd},
+	"subscribe": {"subscribe to new entries, from an offset or as persistent subscription", subscribeCmd},
+	"stats":     {"print the global position and the versions of streams", statsCmd},
+	"list":      {"list streams, optionally filtered by a name prefix", listCmd},
+	"delete":    {"soft or hard delete streams", deleteCmd},
+	"metadata":  {"print or set the retention metadata of a stream", metadataCmd},
+	"schema":    {"list or register schemas validating the payloads of events", schemaCmd},
+	"scavenge":  {"remove events not retained by their streams and print the progress", scavengeCmd},
+	"shred":     {"destroy the keys of aggregates, making the payloads of their events unreadable", shredCmd},
+}
+
+// client bundles the grpc clients and the output format
+type client struct {
+	store     grpcapi.EventStoreClient
+	transport grpcapi.TransportClient
+	schemas   grpcapi.SchemaRegistryClient
+	admin     grpcapi.AdminClient
+	out       printer
+}
+
 func main() {
+	printv := flag.Bool("version", false, "print version")
+	help := flag.Bool("h", false, "print usage")
+	addr := flag.String("addr", "localhost:2006", "address of the eventstore server")
+	useTLS := flag.Bool("tls", false, "connect via TLS")
+	output := flag.String("o", "text", "output format: text or json")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout of unary requests, subscriptions and scavenging run until interrupted")
+	flag.Usage = Usage
+	flag.Parse()
+
+	if *help {
+		Usage()
+		os.Exit(0)
+	}
+	if *printv {
+		fmt.Printf("eventstore-ctl %s - Rev. %s\n", version, revision)
+		os.Exit(0)
+	}
+	if flag.NArg() == 0 {
+		Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", flag.Arg(0))
+		Usage()
+		os.Exit(2)
+	}
+	out, err := newPrinter(*output, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	creds := insecure.NewCredentials()
+	if *useTLS {
+		creds = credentials.NewClientTLSFromCert(nil, "")
+	}
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not connect to %s: %s\n", *addr, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if flag.Arg(0) != "subscribe" && flag.Arg(0) != "scavenge" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	c := &client{
+		store:     grpcapi.NewEventStoreClient(conn),
+		transport: grpcapi.NewTransportClient(conn),
+		schemas:   grpcapi.NewSchemaRegistryClient(conn),
+		admin:     grpcapi.NewAdminClient(conn),
+		out:       out,
+	}
+	if err := cmd.run(ctx, c, flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %s\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
 
+var Usage = func() {
+	fmt.Fprintf(os.Stderr, "Usage of %s: [flags] <command> [command flags] [streams...]\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	for _, name := range []string{"append", "read", "read-at", "subscribe", "stats", "list", "delete", "metadata", "schema", "scavenge", "shred"} {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for the flags of a command.\n", os.Args[0])
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openyard/eventstore/pkg/genproto/grpcapi"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// printer writes results either human readable or as one JSON document per line
+type printer interface {
+	Streams(streams *grpcapi.Streams) error
+	Entries(entries *grpcapi.Entries) error
+	Summary(text string, v any) error
+}
+
+func newPrinter(format string, w io.Writer) (printer, error) {
+	switch format {
+	case "text":
+		return &textPrinter{w}, nil
+	case "json":
+		return &jsonPrinter{w}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+type textPrinter struct {
+	w io.Writer
+}
+
+func (p *textPrinter) Streams(streams *grpcapi.Streams) error {
+	for _, s := range streams.Streams {
+		end := ""
+		if s.EndOfStream {
+			end = ", end of stream"
+		}
+		fmt.Fprintf(p.w, "stream %s (version %d%s)\n", s.Name, s.Version, end)
+		for _, e := range s.Events {
+			fmt.Fprintf(p.w, "  #%-6d %s\n", e.Pos, formatEvent(e))
+		}
+	}
+	return nil
+}
+
+func (p *textPrinter) Entries(entries *grpcapi.Entries) error {
+	for _, e := range entries.Entries {
+		fmt.Fprintf(p.w, "@%-8d %s#%d %s\n", e.GlobalPos, e.StreamName, e.StreamPos, formatEvent(e.Event))
+	}
+	return nil
+}
+
+func (p *textPrinter) Summary(text string, _ any) error {
+	_, err := fmt.Fprintln(p.w, text)
+	return err
+}
+
+func formatEvent(e *grpcapi.Event) string {
+	if e == nil {
+		return "<no event>"
+	}
+	payload := fmt.Sprintf("%d bytes", len(e.Payload))
+	if utf8.Valid(e.Payload) {
+		payload = string(e.Payload)
+	}
+	if e.Redacted {
+		payload = "<redacted>"
+	}
+	headers := ""
+	if e.CorrelationID != "" {
+		headers += " correlation=" + e.CorrelationID
+	}
+	if e.CausationID != "" {
+		headers += " causation=" + e.CausationID
+	}
+	if len(e.Metadata) > 0 {
+		headers += fmt.Sprintf(" metadata=%v", e.Metadata)
+	}
+	return fmt.Sprintf("%s id=%s aggregate=%s occurredAt=%s%s payload=%s",
+		e.Name, e.ID, e.AggregateID, e.OccurredAt.AsTime().Format(time.RFC3339Nano), headers, payload)
+}
+
+type jsonPrinter struct {
+	w io.Writer
+}
+
+func (p *jsonPrinter) Streams(streams *grpcapi.Streams) error {
+	return p.proto(streams)
+}
+
+func (p *jsonPrinter) Entries(entries *grpcapi.Entries) error {
+	return p.proto(entries)
+}
+
+func (p *jsonPrinter) Summary(_ string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.w, "%s\n", raw)
+	return err
+}
+
+func (p *jsonPrinter) proto(m proto.Message) error {
+	raw, err := protojson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.w, "%s\n", raw)
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/openyard/eventstore/pkg/genproto/grpcapi"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var occurredAt = timestamppb.New(time.Date(2024, 9, 14, 11, 0, 0, 0, time.UTC))
+
+func TestFormatEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		event *grpcapi.Event
+		want  string
+	}{
+		{
+			name: "no event",
+			want: "<no event>",
+		},
+		{
+			name:  "text payload",
+			event: &grpcapi.Event{ID: "e-1", Name: "created", AggregateID: "a-1", OccurredAt: occurredAt, Payload: []byte(`{"n":1}`)},
+			want:  `created id=e-1 aggregate=a-1 occurredAt=2024-09-14T11:00:00Z payload={"n":1}`,
+		},
+		{
+			name:  "binary payload",
+			event: &grpcapi.Event{ID: "e-1", Name: "created", AggregateID: "a-1", OccurredAt: occurredAt, Payload: []byte{0xff, 0xfe, 0x00}},
+			want:  "created id=e-1 aggregate=a-1 occurredAt=2024-09-14T11:00:00Z payload=3 bytes",
+		},
+		{
+			name:  "redacted payload",
+			event: &grpcapi.Event{ID: "e-1", Name: "created", AggregateID: "a-1", OccurredAt: occurredAt, Redacted: true},
+			want:  "created id=e-1 aggregate=a-1 occurredAt=2024-09-14T11:00:00Z payload=<redacted>",
+		},
+		{
+			name: "headers",
+			event: &grpcapi.Event{ID: "e-1", Name: "created", AggregateID: "a-1", OccurredAt: occurredAt,
+				CorrelationID: "c-1", CausationID: "e-0", Metadata: map[string]string{"user": "u-1"}},
+			want: "created id=e-1 aggregate=a-1 occurredAt=2024-09-14T11:00:00Z correlation=c-1 causation=e-0 " +
+				"metadata=map[user:u-1] payload=",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatEvent(tt.event); got != tt.want {
+				t.Errorf("formatEvent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	event := &grpcapi.Event{ID: "e-1", Name: "created", AggregateID: "a-1", Pos: 1, OccurredAt: occurredAt,
+		Payload: []byte("x")}
+	streams := &grpcapi.Streams{Streams: []*grpcapi.Stream{{Name: "stream-1", Version: 1, EndOfStream: true,
+		Events: []*grpcapi.Event{event}}}}
+	entries := &grpcapi.Entries{Entries: []*grpcapi.Entry{{GlobalPos: 7, StreamName: "stream-1", StreamPos: 1},
+		{GlobalPos: 8, StreamName: "stream-1", StreamPos: 2, Event: event}}}
+	tests := []struct {
+		name   string
+		format string
+		print  func(p printer) error
+		want   string
+	}{
+		{
+			name:   "text streams",
+			format: "text",
+			print:  func(p printer) error { return p.Streams(streams) },
+			want: "stream stream-1 (version 1, end of stream)\n" +
+				"  #1      created id=e-1 aggregate=a-1 occurredAt=2024-09-14T11:00:00Z payload=x\n",
+		},
+		{
+			name:   "text entries",
+			format: "text",
+			print:  func(p printer) error { return p.Entries(entries) },
+			want: "@7        stream-1#1 <no event>\n" +
+				"@8        stream-1#2 created id=e-1 aggregate=a-1 occurredAt=2024-09-14T11:00:00Z payload=x\n",
+		},
+		{
+			name:   "text summary",
+			format: "text",
+			print:  func(p printer) error { return p.Summary("2 events removed", map[string]int{"removed": 2}) },
+			want:   "2 events removed\n",
+		},
+		{
+			name:   "json summary",
+			format: "json",
+			print:  func(p printer) error { return p.Summary("2 events removed", map[string]int{"removed": 2}) },
+			want:   "{\"removed\":2}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			p, err := newPrinter(tt.format, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.print(p); err != nil {
+				t.Fatalf("print error = %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("print = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrinter_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	p, _ := newPrinter("json", &buf)
+	streams := &grpcapi.Streams{Streams: []*grpcapi.Stream{{Name: "stream-1", Version: 1}}}
+	if err := p.Streams(streams); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Entries(&grpcapi.Entries{Entries: []*grpcapi.Entry{{GlobalPos: 7, StreamName: "stream-1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSuffix(buf.Bytes(), []byte("\n")), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want one JSON document per line: %q", len(lines), buf.String())
+	}
+	for _, want := range [][]byte{[]byte(`"stream-1"`), []byte(`"7"`)} {
+		if !bytes.Contains(buf.Bytes(), want) {
+			t.Errorf("output %q misses %s", buf.String(), want)
+		}
+	}
+}
+
+func TestNewPrinter_UnknownFormat(t *testing.T) {
+	if _, err := newPrinter("yaml", &bytes.Buffer{}); err == nil {
+		t.Error("newPrinter() accepted unknown format")
+	}
+}
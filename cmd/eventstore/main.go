@@ -1,34 +1,152 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/openyard/eventstore/internal/app/eventstore/config"
 	"github.com/openyard/eventstore/internal/app/eventstore/domain"
 	"github.com/openyard/eventstore/internal/app/eventstore/edge"
 	"github.com/openyard/eventstore/internal/app/kvstore"
 	"github.com/openyard/eventstore/pkg/genproto/grpcapi"
+	"github.com/openyard/eventstore/pkg/kvstore/filekv"
 	"github.com/openyard/eventstore/pkg/kvstore/memkv"
+	"github.com/openyard/eventstore/pkg/kvstore/pgkv"
 
+	_ "github.com/lib/pq"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 )
 
-var buckets = []string{kvstore.KvsBucketIndex, kvstore.KvsBucketContent}
+// shutdownTimeout is the time running requests get to finish on shutdown before they are cancelled
+const shutdownTimeout = 10 * time.Second
+
+var buckets = []string{
+	kvstore.KvsBucketIndex,
+	kvstore.KvsBucketContent,
+	kvstore.KvsBucketLog,
+	kvstore.KvsBucketSubscriptions,
+	kvstore.KvsBucketDeleted,
+	kvstore.KvsBucketMeta,
+	kvstore.KvsBucketSchemas,
+	kvstore.KvsBucketKeys,
+}
 
 func main() {
-	s := domain.NewService(domain.WithKeyValueStore(memkv.NewMemoryKVS(buckets...)))
-	t := edge.NewGrpcTransport(edge.WithHandleFunc(s.HandleFunc), edge.WithQueryFunc(s.QueryFunc))
+	cfg, err := config.Load(os.Args[0], os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		config.Usage(os.Stderr)
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\nUsage of %s:\n", err, os.Args[0])
+		config.Usage(os.Stderr)
+		os.Exit(2)
+	}
+	log.SetOutput(cfg.LogWriter(os.Stderr))
+
+	compression, _ := domain.ParseCompression(cfg.Storage.Compression) // validated by config.Load
+	kvs := newKeyValueStore(cfg.Storage)
+	s, err := domain.NewService(
+		domain.WithKeyValueStore(kvs),
+		domain.WithSubscriptionBufferSize(cfg.Limits.SubscriptionBufferSize),
+		domain.WithCompression(compression),
+	)
+	if err != nil {
+		log.Fatalf("[ERROR] couldn't start eventstore: %v", err)
+	}
+	t := edge.NewGrpcTransport(
+		edge.WithNodeID(cfg.NodeID),
+		edge.WithHandleFunc(s.HandleFunc),
+		edge.WithQueryFunc(s.QueryFunc),
+		edge.WithQueryEntriesFunc(s.QueryEntriesFunc),
+		edge.WithQueryMetadataFunc(s.QueryMetadataFunc),
+		edge.WithQueryStreamsFunc(s.QueryStreamsFunc),
+		edge.WithQuerySchemasFunc(s.QuerySchemasFunc),
+	)
 
-	grpcSrv := grpc.NewServer()
+	opts := []grpc.ServerOption{grpc.MaxRecvMsgSize(cfg.Limits.MaxRecvMsgSize)}
+	if cfg.TLS.Enabled() {
+		creds, err := credentials.NewServerTLSFromFile(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			log.Fatalf("[ERROR] [%4d] couldn't load tls credentials: %v", domain.ErrMisconfiguration, err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	grpcSrv := grpc.NewServer(opts...)
 	grpcapi.RegisterEventStoreServer(grpcSrv, t)
 	grpcapi.RegisterTransportServer(grpcSrv, t)
+	grpcapi.RegisterSchemaRegistryServer(grpcSrv, t)
+	grpcapi.RegisterAdminServer(grpcSrv, t)
 	// Register reflection service on gRPC server.
 	reflection.Register(grpcSrv)
 
-	lis, err := net.Listen("tcp", ":2006")
+	lis, err := net.Listen("tcp", cfg.Listen)
 	if err != nil {
-		log.Fatalf("[ERROR] couldn't start listener: %v", err)
+		log.Fatalf("[ERROR] [%4d] couldn't start listener: %v", domain.ErrListen, err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var scavenger sync.WaitGroup
+	if cfg.Storage.ScavengeInterval > 0 {
+		scavenger.Add(1)
+		go func() {
+			defer scavenger.Done()
+			s.ScavengePeriodically(ctx, cfg.Storage.ScavengeInterval)
+		}()
+	}
+	go func() {
+		<-ctx.Done()
+		log.Printf("[INFO]\t shutting down")
+		// subscriptions only end once cancelled, so running requests are cancelled after the timeout
+		timer := time.AfterFunc(shutdownTimeout, grpcSrv.Stop)
+		defer timer.Stop()
+		grpcSrv.GracefulStop()
+	}()
+	log.Printf("[INFO]\t listening on %s (storage: %s, tls: %v)", cfg.Listen, cfg.Storage.Backend, cfg.TLS.Enabled())
+	if err := grpcSrv.Serve(lis); err != nil {
+		log.Panic(err)
+	}
+	scavenger.Wait()
+	if c, ok := kvs.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Fatalf("[ERROR] couldn't close key-value-store: %v", err)
+		}
+	}
+}
+
+func newKeyValueStore(cfg config.Storage) kvstore.KeyValueStore {
+	switch cfg.Backend {
+	case config.BackendFile:
+		var opts []filekv.FileKVSOption
+		if cfg.SyncInterval > 0 {
+			opts = append(opts, filekv.WithSyncInterval(cfg.SyncInterval))
+		}
+		kvs, err := filekv.NewFileKVS(cfg.Path, buckets, opts...)
+		if err != nil {
+			log.Fatalf("[ERROR] couldn't open file key-value-store: %v", err)
+		}
+		return kvs
+	case config.BackendPostgres:
+		db, err := sql.Open("postgres", cfg.DSN)
+		if err != nil {
+			log.Fatalf("[ERROR] couldn't open postgres key-value-store: %v", err)
+		}
+		return pgkv.NewPostgresKVS(db, buckets...)
+	default:
+		return memkv.NewMemoryKVS(buckets...)
 	}
-	log.Panic(grpcSrv.Serve(lis))
 }
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openyard/eventstore/internal/app/eventstore/domain"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	BackendMemory   = "memory"
+	BackendFile     = "file"
+	BackendPostgres = "postgres"
+)
+
+// Config of the eventstore server. Settings are read from a YAML file, environment variables and flags, each
+// overriding the former.
+type Config struct {
+	Listen   string  `yaml:"listen"`
+	NodeID   int64   `yaml:"nodeID"` // snowflake node id of the server
+	LogLevel string  `yaml:"logLevel"`
+	TLS      TLS     `yaml:"tls"`
+	Storage  Storage `yaml:"storage"`
+	Limits   Limits  `yaml:"limits"`
+}
+
+type TLS struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+// Enabled reports whether the server serves TLS
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+type Storage struct {
+	Backend          string        `yaml:"backend"`
+	DSN              string        `yaml:"dsn"`              // data source name of the postgres backend
+	Path             string        `yaml:"path"`             // database file of the file backend
+	SyncInterval     time.Duration `yaml:"syncInterval"`     // 0 fsyncs every write of the file backend
+	ScavengeInterval time.Duration `yaml:"scavengeInterval"` // 0 disables removing events not retained by their streams
+	Compression      string        `yaml:"compression"`      // compression of stored events: none, snappy or zstd
+}
+
+type Limits struct {
+	MaxRecvMsgSize         int `yaml:"maxRecvMsgSize"`         // max size of a request in bytes
+	SubscriptionBufferSize int `yaml:"subscriptionBufferSize"` // max entries buffered per subscriber
+}
+
+// setting binds a config value to its flag and environment variable
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"listen", "EVENTSTORE_LISTEN", "address to listen on",
+		func(c *Config, v string) error { c.Listen = v; return nil }},
+	{"node-id", "EVENTSTORE_NODE_ID", "snowflake node id of the server (0-1023)",
+		func(c *Config, v string) error { return parseInt(v, &c.NodeID) }},
+	{"log-level", "EVENTSTORE_LOG_LEVEL", "min level of log messages: trace, debug, info, warn or error",
+		func(c *Config, v string) error { c.LogLevel = v; return nil }},
+	{"tls-cert-file", "EVENTSTORE_TLS_CERT_FILE", "certificate file to serve TLS",
+		func(c *Config, v string) error { c.TLS.CertFile = v; return nil }},
+	{"tls-key-file", "EVENTSTORE_TLS_KEY_FILE", "private key file to serve TLS",
+		func(c *Config, v string) error { c.TLS.KeyFile = v; return nil }},
+	{"kvs", "EVENTSTORE_STORAGE_BACKEND", "key-value-store backend: memory, file or postgres",
+		func(c *Config, v string) error { c.Storage.Backend = v; return nil }},
+	{"dsn", "EVENTSTORE_STORAGE_DSN", "data source name of the postgres backend",
+		func(c *Config, v string) error { c.Storage.DSN = v; return nil }},
+	{"path", "EVENTSTORE_STORAGE_PATH", "path of the database file of the file backend",
+		func(c *Config, v string) error { c.Storage.Path = v; return nil }},
+	{"sync-interval", "EVENTSTORE_STORAGE_SYNC_INTERVAL", "interval to fsync the file backend, 0 fsyncs every write",
+		func(c *Config, v string) (err error) { c.Storage.SyncInterval, err = time.ParseDuration(v); return err }},
+	{"scavenge-interval", "EVENTSTORE_STORAGE_SCAVENGE_INTERVAL", "interval to remove events not retained, 0 disables scavenging",
+		func(c *Config, v string) (err error) {
+			c.Storage.ScavengeInterval, err = time.ParseDuration(v)
+			return err
+		}},
+	{"compression", "EVENTSTORE_STORAGE_COMPRESSION", "compression of stored events: none, snappy or zstd",
+		func(c *Config, v string) error { c.Storage.Compression = v; return nil }},
+	{"max-recv-msg-size", "EVENTSTORE_MAX_RECV_MSG_SIZE", "max size of a request in bytes",
+		func(c *Config, v string) error { return parseInt(v, &c.Limits.MaxRecvMsgSize) }},
+	{"subscription-buffer-size", "EVENTSTORE_SUBSCRIPTION_BUFFER_SIZE", "max entries buffered per subscriber",
+		func(c *Config, v string) error { return parseInt(v, &c.Limits.SubscriptionBufferSize) }},
+}
+
+// Default returns the configuration used for settings not provided otherwise
+func Default() *Config {
+	return &Config{
+		Listen:   ":2006",
+		NodeID:   1,
+		LogLevel: "debug",
+		Storage: Storage{
+			Backend:     BackendMemory,
+			Path:        "eventstore.db",
+			Compression: domain.NoCompression.String(),
+		},
+		Limits: Limits{
+			MaxRecvMsgSize:         4 << 20,
+			SubscriptionBufferSize: 1024,
+		},
+	}
+}
+
+// Load reads the configuration from the file given by flag -config or environment variable EVENTSTORE_CONFIG and
+// applies environment variables and flags on top. The returned error carries the code ErrMisconfiguration.
+func Load(name string, args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", getenv("EVENTSTORE_CONFIG"), "YAML configuration file")
+	flags := make(map[string]string)
+	for _, s := range settings {
+		fs.Func(s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env), func(v string) error {
+			flags[s.flag] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, misconfiguration(err)
+	}
+
+	c := Default()
+	if *configFile != "" {
+		if err := c.readFile(*configFile); err != nil {
+			return nil, misconfiguration(err)
+		}
+	}
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.set(c, v); err != nil {
+				return nil, misconfiguration(fmt.Errorf("environment variable %s: %w", s.env, err))
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flags[s.flag]; ok {
+			if err := s.set(c, v); err != nil {
+				return nil, misconfiguration(fmt.Errorf("flag -%s: %w", s.flag, err))
+			}
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Usage prints the flags and environment variables of the configuration
+func Usage(w io.Writer) {
+	fmt.Fprintf(w, "  -config string\n    \tYAML configuration file (env EVENTSTORE_CONFIG)\n")
+	for _, s := range settings {
+		fmt.Fprintf(w, "  -%s string\n    \t%s (env %s)\n", s.flag, s.usage, s.env)
+	}
+}
+
+// Validate checks the configuration and reports all invalid settings at once
+func (c *Config) Validate() error {
+	var problems []string
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid listen address <%s>: %s", c.Listen, err))
+	}
+	if c.NodeID < 0 || c.NodeID > 1023 {
+		problems = append(problems, fmt.Sprintf("node id %d out of range 0-1023", c.NodeID))
+	}
+	if _, ok := levels[strings.ToUpper(c.LogLevel)]; !ok {
+		problems = append(problems, fmt.Sprintf("unknown log level <%s>", c.LogLevel))
+	}
+	if c.TLS.Enabled() && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		problems = append(problems, "tls requires both certificate and key file")
+	}
+	switch c.Storage.Backend {
+	case BackendMemory:
+	case BackendFile:
+		if c.Storage.Path == "" {
+			problems = append(problems, "file backend requires a path")
+		}
+		if c.Storage.SyncInterval < 0 {
+			problems = append(problems, "sync interval must not be negative")
+		}
+	case BackendPostgres:
+		if c.Storage.DSN == "" {
+			problems = append(problems, "postgres backend requires a dsn")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown storage backend <%s>", c.Storage.Backend))
+	}
+	if c.Storage.ScavengeInterval < 0 {
+		problems = append(problems, "scavenge interval must not be negative")
+	}
+	if _, err := domain.ParseCompression(c.Storage.Compression); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.Limits.MaxRecvMsgSize <= 0 {
+		problems = append(problems, "max recv msg size must be positive")
+	}
+	if c.Limits.SubscriptionBufferSize <= 0 {
+		problems = append(problems, "subscription buffer size must be positive")
+	}
+	if len(problems) > 0 {
+		return misconfiguration(errors.New(strings.Join(problems, "; ")))
+	}
+	return nil
+}
+
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func misconfiguration(err error) error {
+	return fmt.Errorf("[%4d] invalid configuration: %w", domain.ErrMisconfiguration, err)
+}
+
+func parseInt[T int | int64](v string, target *T) error {
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return err
+	}
+	*target = T(i)
+	return nil
+}
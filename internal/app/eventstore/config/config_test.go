@@ -0,0 +1,92 @@
+package config_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openyard/eventstore/internal/app/eventstore/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "eventstore.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+listen: ":3000"
+nodeID: 7
+storage:
+  backend: file
+  path: /var/lib/eventstore/data.db
+  syncInterval: 250ms
+  scavengeInterval: 1h
+  compression: zstd
+limits:
+  subscriptionBufferSize: 64
+`), 0644))
+	env := map[string]string{
+		"EVENTSTORE_CONFIG":    file,
+		"EVENTSTORE_LISTEN":    ":4000",
+		"EVENTSTORE_LOG_LEVEL": "warn",
+	}
+
+	got, err := config.Load("eventstore", []string{"-listen", ":5000", "-node-id", "9"}, func(k string) string { return env[k] })
+	require.NoError(t, err)
+	assert.Equal(t, ":5000", got.Listen)
+	assert.Equal(t, int64(9), got.NodeID)
+	assert.Equal(t, "warn", got.LogLevel)
+	assert.Equal(t, config.BackendFile, got.Storage.Backend)
+	assert.Equal(t, "/var/lib/eventstore/data.db", got.Storage.Path)
+	assert.Equal(t, 250*time.Millisecond, got.Storage.SyncInterval)
+	assert.Equal(t, time.Hour, got.Storage.ScavengeInterval)
+	assert.Equal(t, "zstd", got.Storage.Compression)
+	assert.Equal(t, 64, got.Limits.SubscriptionBufferSize)
+	assert.Equal(t, config.Default().Limits.MaxRecvMsgSize, got.Limits.MaxRecvMsgSize)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	noEnv := func(string) string { return "" }
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"unknown flag", []string{"-foo", "bar"}, "flag provided but not defined: -foo"},
+		{"invalid node id", []string{"-node-id", "x"}, `flag -node-id: strconv.ParseInt: parsing "x"`},
+		{"missing config file", []string{"-config", "/does/not/exist.yaml"}, "no such file or directory"},
+		{"node id out of range", []string{"-node-id", "1024"}, "node id 1024 out of range 0-1023"},
+		{"postgres without dsn", []string{"-kvs", "postgres"}, "postgres backend requires a dsn"},
+		{"unknown backend", []string{"-kvs", "bolt"}, "unknown storage backend <bolt>"},
+		{"negative scavenge interval", []string{"-scavenge-interval", "-1m"}, "scavenge interval must not be negative"},
+		{"unknown compression", []string{"-compression", "lz4"}, "unknown compression <lz4>"},
+		{"incomplete tls", []string{"-tls-cert-file", "cert.pem"}, "tls requires both certificate and key file"},
+		{"all problems", []string{"-listen", "", "-log-level", "verbose"}, "invalid listen address <>: missing port in address; unknown log level <verbose>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := config.Load("eventstore", tt.args, noEnv)
+			require.Error(t, err)
+			assert.True(t, strings.HasPrefix(err.Error(), "[9103] invalid configuration: "), err.Error())
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
+
+func TestConfig_LogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := (&config.Config{LogLevel: "info"}).LogWriter(&buf)
+	for _, line := range []string{
+		"2024/09/14 12:00:00 [DEBUG]\t dropped\n",
+		"2024/09/14 12:00:00 [ACCESS]\t written\n",
+		"2024/09/14 12:00:00 [ERROR]\t written\n",
+		"2024/09/14 12:00:00 assert buckets: [_index _content]\n",
+	} {
+		_, err := w.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 3, strings.Count(buf.String(), "\n"))
+	assert.NotContains(t, buf.String(), "dropped")
+}
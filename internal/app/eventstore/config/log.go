@@ -0,0 +1,39 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// levels of the tags prefixing log messages, e.g. "[DEBUG]". ACCESS logs are treated like INFO.
+var levels = map[string]int{
+	"TRACE":  0,
+	"DEBUG":  1,
+	"INFO":   2,
+	"ACCESS": 2,
+	"WARN":   3,
+	"ERROR":  4,
+}
+
+// LogWriter returns a writer for log.SetOutput dropping messages tagged below the configured log level. Messages
+// without a known tag are always written.
+func (c *Config) LogWriter(w io.Writer) io.Writer {
+	return &levelWriter{w: w, min: levels[strings.ToUpper(c.LogLevel)]}
+}
+
+type levelWriter struct {
+	w   io.Writer
+	min int
+}
+
+func (l *levelWriter) Write(p []byte) (int, error) {
+	if start := bytes.IndexByte(p, '['); start >= 0 {
+		if end := bytes.IndexByte(p[start:], ']'); end > 0 {
+			if level, ok := levels[string(p[start+1:start+end])]; ok && level < l.min {
+				return len(p), nil
+			}
+		}
+	}
+	return l.w.Write(p)
+}
@@ -10,9 +10,19 @@ const (
 	appendToStreamCommandName      = "event-store/v1.append"
 	readStreamCommandName          = "event-store/v1.read"
 	readStreamAtCommandName        = "event-store/v1.readAt"
+	readAllCommandName             = "event-store/v1.readAll"
 	subscribeCommandName           = "event-store/v1.subscribe"
 	subscribeWithIDCommandName     = "event-store/v1.subscribeWithID"
 	subscribeWithOffsetCommandName = "event-store/v1.subscribeWithOffset"
+	ackCommandName                 = "event-store/v1.ack"
+	deleteStreamCommandName        = "event-store/v1.deleteStream"
+	setStreamMetadataCommandName   = "event-store/v1.setStreamMetadata"
+	getStreamMetadataCommandName   = "event-store/v1.getStreamMetadata"
+	scavengeCommandName            = "event-store/v1.scavenge"
+	listStreamsCommandName         = "event-store/v1.listStreams"
+	registerSchemaCommandName      = "event-store/v1.registerSchema"
+	listSchemasCommandName         = "event-store/v1.listSchemas"
+	shredAggregateCommandName      = "event-store/v1.shredAggregate"
 )
 
 const (
@@ -23,10 +33,53 @@ const (
 	SubscribeCmd
 	SubscribeWithIDCmd
 	SubscribeWithOffsetCmd
+	AckCmd
+	ReadAllCmd
+	DeleteCmd
+	SetMetadataCmd
+	GetMetadataCmd
+	ScavengeCmd
+	ListStreamsCmd
+	RegisterSchemaCmd
+	ListSchemasCmd
+	ShredAggregateCmd
+)
+
+const (
+	Forward Direction = iota
+	Backward
+)
+
+const (
+	Exact        ExpectedVersion = iota // the stream must be at the expected version, 0 for a new stream
+	Any                                 // the stream may be at any version or not exist
+	NoStream                            // the stream must not exist
+	StreamExists                        // the stream must exist at any version
 )
 
 type CommandKind uint8
 
+// Direction defines the order in which entries are read
+type Direction uint8
+
+// ExpectedVersion defines how the version of a stream is checked before appending to it
+type ExpectedVersion uint8
+
+func (ev ExpectedVersion) String() string {
+	switch ev {
+	case Exact:
+		return "exact"
+	case Any:
+		return "any"
+	case NoStream:
+		return "no-stream"
+	case StreamExists:
+		return "stream-exists"
+	default:
+		return "unknown"
+	}
+}
+
 func NewCommand(ctx context.Context, kind CommandKind, payload any) Command {
 	return Command{ctx, kind, payload}
 }
@@ -47,26 +100,128 @@ type AppendCommand struct {
 
 type StreamData struct {
 	name            string
+	mode            ExpectedVersion
 	expectedVersion uint64
 	events          []*Event
 }
 
+// NewStreamData returns stream data to append to the stream if it is exactly at the expected version
 func NewStreamData(name string, expectedVersion uint64, events ...*Event) StreamData {
+	return NewStreamDataExpecting(name, Exact, expectedVersion, events...)
+}
+
+// NewStreamDataExpecting returns stream data to append to the stream if its version satisfies the mode. The expected
+// version is only checked in mode Exact.
+func NewStreamDataExpecting(name string, mode ExpectedVersion, expectedVersion uint64, events ...*Event) StreamData {
 	return StreamData{
 		name:            name,
+		mode:            mode,
 		expectedVersion: expectedVersion,
 		events:          events,
 	}
 }
 
+// accepts reports whether a stream at the version satisfies the expected version
+func (ev ExpectedVersion) accepts(expected, version uint64, exists bool) bool {
+	switch ev {
+	case Any:
+		return true
+	case NoStream:
+		return !exists
+	case StreamExists:
+		return exists
+	default:
+		return version == expected
+	}
+}
+
+// DeleteStream returns a command to delete the stream if its version satisfies the mode. A soft deleted stream is
+// hidden from reads and continues at its version when appended to again, a hard deleted stream is removed and
+// tombstoned, so it can't be recreated.
+func DeleteStream(name string, mode ExpectedVersion, expectedVersion uint64, hard bool) DeleteCommand {
+	return DeleteCommand{
+		name:            name,
+		mode:            mode,
+		expectedVersion: expectedVersion,
+		hard:            hard,
+	}
+}
+
+type DeleteCommand struct {
+	name            string
+	mode            ExpectedVersion
+	expectedVersion uint64
+	hard            bool
+}
+
+// SetStreamMetadata returns a command to replace the metadata of the stream if the version of its metadata satisfies
+// the mode. Streams without metadata are at metadata version 0.
+func SetStreamMetadata(name string, mode ExpectedVersion, expectedVersion uint64, metadata StreamMetadata) SetMetadataCommand {
+	return SetMetadataCommand{
+		name:            name,
+		mode:            mode,
+		expectedVersion: expectedVersion,
+		metadata:        metadata,
+	}
+}
+
+type SetMetadataCommand struct {
+	name            string
+	mode            ExpectedVersion
+	expectedVersion uint64
+	metadata        StreamMetadata
+}
+
+func GetStreamMetadata(name string) GetMetadataCommand {
+	return GetMetadataCommand{name: name}
+}
+
+type GetMetadataCommand struct {
+	name string
+}
+
+// Scavenge returns a command to remove the events not retained by their streams, reporting the progress after each
+// batch of entries of the global log and once the run is done
+func Scavenge(progress func(ScavengeProgress) error) ScavengeCommand {
+	return ScavengeCommand{progress: progress}
+}
+
+type ScavengeCommand struct {
+	progress func(ScavengeProgress) error
+}
+
+// ShredAggregate returns a command to destroy the data key of the aggregate, making the payloads of its events
+// unreadable
+func ShredAggregate(aggregateID string) ShredAggregateCommand {
+	return ShredAggregateCommand{aggregateID: aggregateID}
+}
+
+type ShredAggregateCommand struct {
+	aggregateID string
+}
+
 func Read(streams ...string) ReadCommand {
 	return ReadCommand{
 		streams: streams,
 	}
 }
 
+// ReadRange returns a command to read up to maxCount events of each stream starting at stream position from.
+// Reading backward from position 0 starts at the last event of the stream. A maxCount of 0 reads all events.
+func ReadRange(from uint64, maxCount uint32, direction Direction, streams ...string) ReadCommand {
+	return ReadCommand{
+		streams:   streams,
+		from:      from,
+		maxCount:  maxCount,
+		direction: direction,
+	}
+}
+
 type ReadCommand struct {
-	streams []string
+	streams   []string
+	from      uint64
+	maxCount  uint32
+	direction Direction
 }
 
 func ReadAt(at time.Time, streams ...string) ReadAtCommand {
@@ -80,3 +235,113 @@ type ReadAtCommand struct {
 	streams []string
 	at      time.Time
 }
+
+// ReadAll returns a command to read up to maxCount entries of all streams in the order of the global log starting
+// at position. Reading backward from position 0 starts at the head of the log.
+func ReadAll(position uint64, maxCount uint32, direction Direction) ReadAllCommand {
+	return ReadAllCommand{
+		position:  position,
+		maxCount:  maxCount,
+		direction: direction,
+	}
+}
+
+type ReadAllCommand struct {
+	position  uint64
+	maxCount  uint32
+	direction Direction
+}
+
+// ListStreams returns a command to list up to pageSize streams whose names start with prefix in the order of their
+// names, continuing after the stream named by the continuation token. A pageSize of 0 lists the default count.
+func ListStreams(prefix, continuationToken string, pageSize uint32) ListStreamsCommand {
+	return ListStreamsCommand{
+		prefix:            prefix,
+		continuationToken: continuationToken,
+		pageSize:          pageSize,
+	}
+}
+
+type ListStreamsCommand struct {
+	prefix            string
+	continuationToken string
+	pageSize          uint32
+}
+
+// RegisterSchema returns a command to register the schema as next version of the schema of its event name if the
+// current version satisfies the expected version in the mode. Event names without schema are at version 0.
+func RegisterSchema(mode ExpectedVersion, expectedVersion uint64, schema Schema) RegisterSchemaCommand {
+	return RegisterSchemaCommand{
+		mode:            mode,
+		expectedVersion: expectedVersion,
+		schema:          schema,
+	}
+}
+
+type RegisterSchemaCommand struct {
+	mode            ExpectedVersion
+	expectedVersion uint64
+	schema          Schema
+}
+
+// ListSchemas returns a command to list all versions of the schema of the event name. Without event name the latest
+// versions of the schemas of all event names are listed.
+func ListSchemas(eventName string) ListSchemasCommand {
+	return ListSchemasCommand{eventName: eventName}
+}
+
+type ListSchemasCommand struct {
+	eventName string
+}
+
+func Subscribe(limit uint32, deliver func(entries []Entry) error) SubscribeCommand {
+	return SubscribeCommand{
+		limit:   limit,
+		deliver: deliver,
+	}
+}
+
+type SubscribeCommand struct {
+	limit   uint32
+	deliver func(entries []Entry) error
+}
+
+func SubscribeWithOffset(offset uint64, limit uint32, deliver func(entries []Entry) error) SubscribeWithOffsetCommand {
+	return SubscribeWithOffsetCommand{
+		offset:  offset,
+		limit:   limit,
+		deliver: deliver,
+	}
+}
+
+type SubscribeWithOffsetCommand struct {
+	offset  uint64
+	limit   uint32
+	deliver func(entries []Entry) error
+}
+
+func SubscribeWithID(subscriptionID string, limit uint32, deliver func(entries []Entry) error) SubscribeWithIDCommand {
+	return SubscribeWithIDCommand{
+		subscriptionID: subscriptionID,
+		limit:          limit,
+		deliver:        deliver,
+	}
+}
+
+type SubscribeWithIDCommand struct {
+	subscriptionID string
+	limit          uint32
+	deliver        func(entries []Entry) error
+}
+
+func Ack(subscriptionID string, position uint64) AckCommand {
+	return AckCommand{
+		subscriptionID: subscriptionID,
+		position:       position,
+	}
+}
+
+type AckCommand struct {
+	subscriptionID string
+	position       uint64
+}
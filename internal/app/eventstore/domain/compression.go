@@ -0,0 +1,93 @@
+package domain
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the algorithm compressing the records of the content bucket and the global log and the payloads
+// sealed in them
+type Compression uint8
+
+const (
+	NoCompression Compression = iota // records are stored as JSON
+	Snappy                           // fast compression at a moderate ratio
+	Zstd                             // higher ratio at a moderate speed
+)
+
+// compressedMarker starts compressed records, followed by the compression. Records stored as JSON never start with
+// it, so records of all compressions remain readable.
+const compressedMarker = 0x00
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Snappy:
+		return "snappy"
+	case Zstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown(%d)", c)
+	}
+}
+
+// ParseCompression returns the compression with the name: none, snappy or zstd
+func ParseCompression(name string) (Compression, error) {
+	for _, c := range []Compression{NoCompression, Snappy, Zstd} {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+	return NoCompression, fmt.Errorf("unknown compression <%s>", name)
+}
+
+// WithCompression sets the compression of records written from now on, records written before stay readable
+func WithCompression(c Compression) ServiceOpts {
+	return func(s *Service) {
+		s.compression = c
+	}
+}
+
+// compress returns the record compressed by the compression of the service and prefixed by the compressed marker
+func (s *Service) compress(record []byte) []byte {
+	switch s.compression {
+	case Snappy:
+		return append([]byte{compressedMarker, byte(Snappy)}, snappy.Encode(nil, record)...)
+	case Zstd:
+		return zstdEncoder.EncodeAll(record, []byte{compressedMarker, byte(Zstd)})
+	default:
+		return record
+	}
+}
+
+// decompress returns the record stored by compress with any compression
+func decompress(raw []byte) ([]byte, error) {
+	if len(raw) == 0 || raw[0] != compressedMarker {
+		return raw, nil
+	}
+	if len(raw) < 2 {
+		return nil, newError(ErrReadStreamFailed, "invalid compressed record")
+	}
+	var record []byte
+	var err error
+	switch c := Compression(raw[1]); c {
+	case Snappy:
+		record, err = snappy.Decode(nil, raw[2:])
+	case Zstd:
+		record, err = zstdDecoder.DecodeAll(raw[2:], nil)
+	default:
+		return nil, newError(ErrReadStreamFailed, "record compressed by %s", c)
+	}
+	if err != nil {
+		return nil, wrapError(ErrReadStreamFailed, err, "decompress %s record failed", Compression(raw[1]))
+	}
+	return record, nil
+}
@@ -0,0 +1,119 @@
+package domain
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+)
+
+// deletion of a stream, the events up to its version are hidden from reads
+type deletion struct {
+	version   uint64
+	tombstone bool // the stream is hard deleted and can't be recreated
+}
+
+// streamState describes a stream as visible to clients
+type streamState struct {
+	version uint64 // version of the stream including deleted events
+	first   uint64 // 0-based position of the first event not deleted
+	exists  bool
+}
+
+func (s *Service) deleteStream(_ context.Context, cmd DeleteCommand) error {
+	if cmd.name == "" || cmd.name == allStreamName {
+		return newError(ErrInvalidRequest, "invalid stream name <%s>", cmd.name)
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := s.kvs.WithTx(func(rw kvstore.ReadWriter) error {
+		state, err := s.readStreamState(rw, cmd.name)
+		if err != nil {
+			return err
+		}
+		if !state.exists && state.first == 0 {
+			return &StreamNotFoundError{Stream: cmd.name}
+		}
+		if !cmd.mode.accepts(cmd.expectedVersion, state.version, state.exists) {
+			return &VersionMismatchError{Stream: cmd.name, Mode: cmd.mode, Expected: cmd.expectedVersion, Actual: state.version}
+		}
+		if err := s.migrate(rw, cmd.name, state.version); err != nil {
+			return err
+		}
+		if !cmd.hard {
+			log.Printf("[INFO]\t %T.deleteStream - soft delete stream <%s> at version %d", s, cmd.name, state.version)
+			return s.writeDeletion(rw, cmd.name, deletion{version: state.version})
+		}
+		log.Printf("[INFO]\t %T.deleteStream - hard delete stream <%s> at version %d", s, cmd.name, state.version)
+		return s.removeStream(rw, cmd.name, state.version)
+	}); err != nil {
+		s.kvs.Rollback()
+		return storageError(err)
+	}
+	return nil
+}
+
+// removeStream deletes the events and the index of the stream and tombstones it. Its entries in the global log are
+// kept.
+func (s *Service) removeStream(rw kvstore.ReadWriter, stream string, version uint64) error {
+	// the content of legacy streams is stored under the name of the stream
+	if err := rw.Delete(kvstore.KvsBucketContent, stream); err != nil {
+		return err
+	}
+	for pos := uint64(1); pos <= version; pos++ {
+		if err := rw.Delete(kvstore.KvsBucketContent, eventKey(stream, pos)); err != nil {
+			return err
+		}
+	}
+	if err := rw.Delete(kvstore.KvsBucketIndex, stream); err != nil {
+		return err
+	}
+	return s.writeDeletion(rw, stream, deletion{version: version, tombstone: true})
+}
+
+// readStreamState returns the state of the stream or an error if the stream is hard deleted
+func (s *Service) readStreamState(rw kvstore.ReadWriter, stream string) (streamState, error) {
+	d, err := s.readDeletion(rw, stream)
+	if err != nil {
+		return streamState{}, err
+	}
+	if d != nil && d.tombstone {
+		return streamState{}, &StreamDeletedError{Stream: stream}
+	}
+	version, err := s.readVersion(rw, stream)
+	if err != nil && ErrorCode(err) != ErrStreamNotFound {
+		return streamState{}, err
+	}
+	state := streamState{version: version, exists: err == nil}
+	if d != nil {
+		state.first = d.version
+		state.exists = state.exists && version > d.version
+	}
+	return state, nil
+}
+
+// readDeletion returns the deletion of the stream or nil if the stream was never deleted
+func (s *Service) readDeletion(rw kvstore.ReadWriter, stream string) (*deletion, error) {
+	raw, err := rw.Get(kvstore.KvsBucketDeleted, stream)
+	if errors.Is(err, kvstore.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	if len(raw) != 9 {
+		return nil, newError(ErrReadStreamFailed, "invalid deletion of stream <%s>", stream)
+	}
+	return &deletion{version: binary.BigEndian.Uint64(raw), tombstone: raw[8] == 1}, nil
+}
+
+func (s *Service) writeDeletion(rw kvstore.ReadWriter, stream string, d deletion) error {
+	raw := make([]byte, 9)
+	binary.BigEndian.PutUint64(raw, d.version)
+	if d.tombstone {
+		raw[8] = 1
+	}
+	return rw.Put(kvstore.KvsBucketDeleted, stream, raw)
+}
@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"time"
 )
@@ -13,21 +14,54 @@ type Entry struct {
 }
 
 type Event struct {
-	id          string
-	name        string
-	aggregateID string
-	occurredAt  time.Time
-	payload     []byte
+	id            string
+	name          string
+	aggregateID   string
+	occurredAt    time.Time
+	payload       []byte
+	correlationID string            // ID shared by all events caused by the same request
+	causationID   string            // ID of the event or command causing the event
+	metadata      map[string]string // headers of the event like user IDs or the content-type of the payload
+	encrypted     bool              // payload is sealed with the data key of the aggregate, only set in storage
+	compressed    bool              // payload is compressed before it was sealed, only set in storage
+	redacted      bool              // payload is unreadable since the data key of the aggregate was destroyed
 }
 
-func NewEventAt(id, name, aggregateID string, occurredAt time.Time, payload []byte) *Event {
-	return &Event{
+type EventOption func(*Event)
+
+func NewEventAt(id, name, aggregateID string, occurredAt time.Time, payload []byte, opts ...EventOption) *Event {
+	e := &Event{
 		id:          id,
 		name:        name,
 		aggregateID: aggregateID,
 		occurredAt:  occurredAt,
 		payload:     payload,
 	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+func WithCorrelationID(correlationID string) EventOption {
+	return func(e *Event) {
+		e.correlationID = correlationID
+	}
+}
+
+func WithCausationID(causationID string) EventOption {
+	return func(e *Event) {
+		e.causationID = causationID
+	}
+}
+
+// WithEventMetadata sets the headers of the event, empty metadata is stored as nil
+func WithEventMetadata(metadata map[string]string) EventOption {
+	return func(e *Event) {
+		if len(metadata) > 0 {
+			e.metadata = metadata
+		}
+	}
 }
 
 func (e *Event) ID() string {
@@ -50,6 +84,24 @@ func (e *Event) OccurredAt() time.Time {
 	return e.occurredAt
 }
 
+func (e *Event) CorrelationID() string {
+	return e.correlationID
+}
+
+func (e *Event) CausationID() string {
+	return e.causationID
+}
+
+// Metadata returns the headers of the event, nil if it has none
+func (e *Event) Metadata() map[string]string {
+	return e.metadata
+}
+
+// Redacted tells if the payload of the event is unreadable since its aggregate was shredded
+func (e *Event) Redacted() bool {
+	return e.redacted
+}
+
 // MarshalJSON is implementation of json.Marshaler
 func (e *Event) MarshalJSON() ([]byte, error) {
 	v := map[string]any{
@@ -59,6 +111,22 @@ func (e *Event) MarshalJSON() ([]byte, error) {
 		"Payload":     e.payload,
 		"OccurredAt":  e.occurredAt,
 	}
+	// the optional fields are omitted, so events stored without them keep their encoding
+	if e.correlationID != "" {
+		v["CorrelationID"] = e.correlationID
+	}
+	if e.causationID != "" {
+		v["CausationID"] = e.causationID
+	}
+	if len(e.metadata) > 0 {
+		v["Metadata"] = e.metadata
+	}
+	if e.encrypted {
+		v["Encrypted"] = true
+	}
+	if e.compressed {
+		v["Compressed"] = true
+	}
 	return json.MarshalIndent(v, "", "  ")
 }
 
@@ -72,8 +140,22 @@ func (e *Event) UnmarshalJSON(data []byte) error {
 	e.id = v["ID"].(string)
 	e.aggregateID = v["AggregateID"].(string)
 	if v["Payload"] != nil {
-		e.payload = []byte(v["Payload"].(string))
+		payload, err := base64.StdEncoding.DecodeString(v["Payload"].(string))
+		if err != nil {
+			return err
+		}
+		e.payload = payload
 	}
 	e.occurredAt, _ = time.Parse(time.RFC3339Nano, v["OccurredAt"].(string))
+	e.correlationID, _ = v["CorrelationID"].(string)
+	e.causationID, _ = v["CausationID"].(string)
+	if metadata, ok := v["Metadata"].(map[string]any); ok {
+		e.metadata = make(map[string]string, len(metadata))
+		for key, value := range metadata {
+			e.metadata[key], _ = value.(string)
+		}
+	}
+	e.encrypted, _ = v["Encrypted"].(bool)
+	e.compressed, _ = v["Compressed"].(bool)
 	return nil
 }
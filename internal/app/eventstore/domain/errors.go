@@ -1,9 +1,142 @@
 package domain
 
+import (
+	"errors"
+	"fmt"
+)
+
 // error codes
 const (
 	ErrReadStreamFailed = iota + 9101
 	ErrConcurrentChange
 	ErrMisconfiguration
 	ErrListen
+	ErrSubscriptionDropped
+	ErrInvalidRequest
+	ErrStreamNotFound
+	ErrStorageFailed
+	ErrEventsOverlap
+	ErrStreamDeleted
+	ErrScavengeRunning
+	ErrSchemaViolation
+	ErrAggregateShredded
 )
+
+// CodedError is implemented by all errors returned by the service
+type CodedError interface {
+	error
+	Code() int
+}
+
+// ErrorCode returns the error code of err or 0 if err carries no error code
+func ErrorCode(err error) int {
+	var coded CodedError
+	if errors.As(err, &coded) {
+		return coded.Code()
+	}
+	return 0
+}
+
+// Error carries one of the error codes and optionally the error causing it
+type Error struct {
+	code  int
+	msg   string
+	cause error
+}
+
+func newError(code int, format string, args ...any) *Error {
+	return &Error{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+func wrapError(code int, cause error, format string, args ...any) *Error {
+	return &Error{code: code, msg: fmt.Sprintf(format, args...), cause: cause}
+}
+
+// storageError wraps errors of the key-value-store not carrying an error code yet
+func storageError(err error) error {
+	if err == nil || ErrorCode(err) != 0 {
+		return err
+	}
+	return wrapError(ErrStorageFailed, err, "storage failure")
+}
+
+func (e *Error) Code() int {
+	return e.code
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("[%4d] %s: %s", e.code, e.msg, e.cause)
+	}
+	return fmt.Sprintf("[%4d] %s", e.code, e.msg)
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// VersionMismatchError is returned if the version of a stream does not satisfy the expected version
+type VersionMismatchError struct {
+	Stream   string
+	Mode     ExpectedVersion
+	Expected uint64 // only set in mode Exact
+	Actual   uint64
+}
+
+func (e *VersionMismatchError) Code() int {
+	return ErrConcurrentChange
+}
+
+func (e *VersionMismatchError) Error() string {
+	if e.Mode != Exact {
+		return fmt.Sprintf("[%4d] concurrent write mismatch index of stream <%s>: %d(actual), expected %s",
+			ErrConcurrentChange, e.Stream, e.Actual, e.Mode)
+	}
+	return fmt.Sprintf("[%4d] concurrent write mismatch index of stream <%s>: %d(actual) != %d(expected)",
+		ErrConcurrentChange, e.Stream, e.Actual, e.Expected)
+}
+
+// StreamNotFoundError is returned when reading a stream which does not exist
+type StreamNotFoundError struct {
+	Stream string
+}
+
+func (e *StreamNotFoundError) Code() int {
+	return ErrStreamNotFound
+}
+
+func (e *StreamNotFoundError) Error() string {
+	return fmt.Sprintf("[%4d] stream <%s> not found", ErrStreamNotFound, e.Stream)
+}
+
+// StreamDeletedError is returned when reading or writing a hard deleted stream
+type StreamDeletedError struct {
+	Stream string
+}
+
+func (e *StreamDeletedError) Code() int {
+	return ErrStreamDeleted
+}
+
+func (e *StreamDeletedError) Error() string {
+	return fmt.Sprintf("[%4d] stream <%s> is deleted", ErrStreamDeleted, e.Stream)
+}
+
+// SchemaViolationError is returned when appending an event whose payload does not validate against the schema
+// registered for its name
+type SchemaViolationError struct {
+	Stream        string
+	EventID       string
+	EventName     string
+	SchemaVersion uint64
+	Reason        string
+}
+
+func (e *SchemaViolationError) Code() int {
+	return ErrSchemaViolation
+}
+
+func (e *SchemaViolationError) Error() string {
+	return fmt.Sprintf("[%4d] payload of event <%s> (%s) in stream <%s> violates schema version %d: %s",
+		ErrSchemaViolation, e.EventID, e.EventName, e.Stream, e.SchemaVersion, e.Reason)
+}
@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"sort"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+)
+
+// alreadyWritten reports whether the events of the stream data were written by a previous append, comparing the
+// IDs of the events with the IDs of the events at the positions the stream data was supposed to be written to.
+// Events without ID are never considered as written. Appends in mode Any or StreamExists are compared to the last
+// events of the stream. A batch overlapping written events only partially is rejected. Positions before the first
+// retained event are not compared since their events may be removed, a batch without retained positions is
+// considered as not written.
+func (s *Service) alreadyWritten(rw kvstore.ReadWriter, streamData StreamData, state streamState) (bool, error) {
+	meta, _, err := s.readMetadata(rw, streamData.name)
+	if err != nil {
+		return false, err
+	}
+	events, version := streamData.events, state.version
+	first := max(state.first, meta.first(version))
+	from := streamData.expectedVersion
+	switch streamData.mode {
+	case NoStream:
+		from = state.first
+	case Any, StreamExists:
+		from = version - min(uint64(len(events)), version-min(first, version))
+	}
+	if len(events) == 0 || max(from, first) >= version {
+		return false, nil
+	}
+	if from == 0 {
+		// the events of new streams are written in the order they occurred
+		events = append(make([]*Event, 0, len(events)), events...)
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].OccurredAt().Before(events[j].OccurredAt())
+		})
+	}
+	positions := make([]uint64, 0, len(events))
+	for pos := max(from, first); pos < version && pos < from+uint64(len(events)); pos++ {
+		positions = append(positions, pos)
+	}
+	written, err := s.readEvents(rw, streamData.name, positions)
+	if err != nil {
+		return false, err
+	}
+	matches := 0
+	for _, pos := range positions {
+		if e := events[pos-from]; e.ID() != "" && e.ID() == written[pos].ID() {
+			matches++
+		}
+	}
+	switch {
+	case matches == 0:
+		return false, nil
+	case matches == len(positions) && from+uint64(len(events)) <= version:
+		return true, nil
+	default:
+		return false, newError(ErrEventsOverlap, "%d of %d events of stream <%s> already written at position %d",
+			matches, len(events), streamData.name, from+1)
+	}
+}
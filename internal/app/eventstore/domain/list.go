@@ -0,0 +1,106 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+)
+
+// defaultListStreamsCount is the max count of streams returned by listStreams if the request provides no page size
+const defaultListStreamsCount = 100
+
+// StreamInfo describes a stream returned by ListStreams. The event times are zero if the stream retains no event.
+type StreamInfo struct {
+	Name         string
+	Version      uint64
+	FirstEventAt time.Time // occurrence of the first retained event
+	LastEventAt  time.Time // occurrence of the last retained event
+}
+
+// listStreams scans the index for the streams visible to clients and returns a page of them with the token of the
+// next page. Deleted streams are skipped, so the index is scanned until the page is full.
+func (s *Service) listStreams(_ context.Context, cmd ListStreamsCommand) ([]StreamInfo, string, error) {
+	pageSize := int(cmd.pageSize)
+	if pageSize == 0 {
+		pageSize = defaultListStreamsCount
+	}
+	now := time.Now()
+	result := make([]StreamInfo, 0)
+	cursor := cmd.continuationToken
+	for {
+		kvs, next, err := s.kvs.Scan(kvstore.KvsBucketIndex, cmd.prefix, cursor, pageSize-len(result))
+		if err != nil {
+			return result, "", storageError(err)
+		}
+		for _, kv := range kvs {
+			cursor = kv.Key
+			if kv.Key == allStreamName {
+				continue
+			}
+			info, ok, err := s.streamInfo(kv.Key, now)
+			if err != nil {
+				return result, "", err
+			}
+			if ok {
+				result = append(result, info)
+			}
+		}
+		switch {
+		case next == "":
+			return result, "", nil
+		case len(result) == pageSize:
+			return result, cursor, nil
+		}
+	}
+}
+
+// streamInfo describes the stream, streams not visible to clients are reported as not ok
+func (s *Service) streamInfo(name string, now time.Time) (StreamInfo, bool, error) {
+	state, err := s.readStreamState(s.kvs, name)
+	if ErrorCode(err) == ErrStreamDeleted {
+		return StreamInfo{}, false, nil
+	}
+	if err != nil || !state.exists {
+		return StreamInfo{}, false, err
+	}
+	if err := s.assertMigrated(name, state.version); err != nil {
+		return StreamInfo{}, false, err
+	}
+	meta, _, err := s.readMetadata(s.kvs, name)
+	if err != nil {
+		return StreamInfo{}, false, err
+	}
+	info := StreamInfo{Name: name, Version: state.version}
+	first := max(state.first, meta.first(state.version))
+	for pos := first; pos < state.version; pos++ {
+		e, err := s.retainedEvent(name, pos, meta, now)
+		if err != nil {
+			return StreamInfo{}, false, err
+		}
+		if e != nil {
+			info.FirstEventAt = e.OccurredAt()
+			break
+		}
+	}
+	for pos := state.version; pos > first; pos-- {
+		e, err := s.retainedEvent(name, pos-1, meta, now)
+		if err != nil {
+			return StreamInfo{}, false, err
+		}
+		if e != nil {
+			info.LastEventAt = e.OccurredAt()
+			break
+		}
+	}
+	return info, true, nil
+}
+
+// retainedEvent returns the event at the 0-based position if the metadata retains it, nil otherwise
+func (s *Service) retainedEvent(stream string, pos uint64, meta StreamMetadata, now time.Time) (*Event, error) {
+	events, err := s.readEvents(s.kvs, stream, []uint64{pos})
+	if err != nil || !meta.retains(events[pos], now) {
+		return nil, err
+	}
+	return events[pos], nil
+}
@@ -0,0 +1,103 @@
+package domain
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+)
+
+// allStreamName is the reserved name of the stream spanning all streams. Its index entry holds the global position
+// of the last committed entry.
+const allStreamName = "$all"
+
+// logKey returns the key of an entry in the global log. Positions are zero padded to keep keys in order.
+func logKey(pos uint64) string {
+	return fmt.Sprintf("%020d", pos)
+}
+
+// writeLog stores the entries in the global log under their global position and advances the head of the log. The
+// payloads are stored encrypted.
+func (s *Service) writeLog(rw kvstore.ReadWriter, entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	for _, e := range entries {
+		var err error
+		if e.Event, err = s.seal(rw, e.Event); err != nil {
+			return err
+		}
+		raw, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := rw.Put(kvstore.KvsBucketLog, logKey(e.GlobalPos), s.compress(raw)); err != nil {
+			return err
+		}
+	}
+	head := make([]byte, 8)
+	binary.BigEndian.PutUint64(head, entries[len(entries)-1].GlobalPos)
+	return rw.Put(kvstore.KvsBucketIndex, allStreamName, head)
+}
+
+// readLog returns the entries of the global log in the range [from, to], scanning the log in the order of its keys
+func (s *Service) readLog(from, to uint64) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	if from == 0 || to < from {
+		return entries, nil
+	}
+	kvs, _, err := s.kvs.Scan(kvstore.KvsBucketLog, "", logKey(from-1), int(to-from+1))
+	if err != nil {
+		return entries, wrapError(ErrReadStreamFailed, err, "read global log from position %d failed", from)
+	}
+	for _, kv := range kvs {
+		raw, err := decompress(kv.Value)
+		if err != nil {
+			return entries, err
+		}
+		var e Entry
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return entries, err
+		}
+		if pos := from + uint64(len(entries)); e.GlobalPos != pos {
+			return entries, newError(ErrReadStreamFailed, "read global log at position %d failed: entry missing", pos)
+		}
+		if e.Event, err = s.open(s.kvs, e.Event); err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	if pos := from + uint64(len(entries)); pos <= to {
+		return entries, newError(ErrReadStreamFailed, "read global log at position %d failed: entry missing", pos)
+	}
+	return entries, nil
+}
+
+// head returns the global position of the last committed entry
+func (s *Service) head() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.position
+}
+
+// recoverPosition restores the global position of the last committed entry from the head of the global log. Only a
+// missing head starts the log at position 0, otherwise appends would overwrite the entries of the log.
+func (s *Service) recoverPosition() error {
+	raw, err := s.kvs.Get(kvstore.KvsBucketIndex, allStreamName)
+	if errors.Is(err, kvstore.ErrNotFound) {
+		log.Printf("[DEBUG]\t %T.recoverPosition - no global log found: starting at position 0", s)
+		return nil
+	}
+	if err != nil {
+		return storageError(err)
+	}
+	if len(raw) != 8 {
+		return newError(ErrReadStreamFailed, "invalid head of the global log")
+	}
+	s.position = binary.BigEndian.Uint64(raw)
+	log.Printf("[INFO]\t %T.recoverPosition - recovered global log at position %d", s, s.position)
+	return nil
+}
@@ -0,0 +1,176 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+)
+
+// StreamMetadata controls the retention of the events of a stream. Events not retained are hidden from reads and
+// subscriptions.
+type StreamMetadata struct {
+	MaxCount       uint64        // retain the last MaxCount events, 0 retains all events
+	MaxAge         time.Duration // retain the events occurred within MaxAge, 0 retains all events
+	TruncateBefore uint64        // retain the events from the 1-based position on, 0 retains all events
+	scavenged      uint64        // 0-based position up to which expired events were removed by the scavenger
+}
+
+// storedMetadata is the metadata of a stream as stored in the metadata bucket
+type storedMetadata struct {
+	Version        uint64
+	MaxCount       uint64
+	MaxAge         time.Duration
+	TruncateBefore uint64
+	Scavenged      uint64 `json:",omitempty"`
+}
+
+// first returns the 0-based position of the first event retained by the stream in the given version
+func (m StreamMetadata) first(version uint64) uint64 {
+	first := max(max(m.TruncateBefore, 1)-1, m.scavenged)
+	if m.MaxCount > 0 && version > m.MaxCount {
+		first = max(first, version-m.MaxCount)
+	}
+	return first
+}
+
+// retains reports whether the event is retained at the given time, not considering its position. Events removed
+// by the scavenger are nil and never retained.
+func (m StreamMetadata) retains(e *Event, now time.Time) bool {
+	return e != nil && (m.MaxAge == 0 || !e.OccurredAt().Before(now.Add(-m.MaxAge)))
+}
+
+func (s *Service) setMetadata(_ context.Context, cmd SetMetadataCommand) error {
+	if cmd.name == "" || cmd.name == allStreamName {
+		return newError(ErrInvalidRequest, "invalid stream name <%s>", cmd.name)
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := s.kvs.WithTx(func(rw kvstore.ReadWriter) error {
+		if _, err := s.readStreamState(rw, cmd.name); err != nil {
+			return err
+		}
+		current, version, err := s.readMetadata(rw, cmd.name)
+		if err != nil {
+			return err
+		}
+		if !cmd.mode.accepts(cmd.expectedVersion, version, version > 0) {
+			return &VersionMismatchError{Stream: metadataStream(cmd.name), Mode: cmd.mode,
+				Expected: cmd.expectedVersion, Actual: version}
+		}
+		log.Printf("[INFO]\t %T.setMetadata - set metadata of stream <%s> to %+v", s, cmd.name, cmd.metadata)
+		metadata := cmd.metadata
+		metadata.scavenged = current.scavenged
+		return s.writeMetadata(rw, cmd.name, version+1, metadata)
+	}); err != nil {
+		s.kvs.Rollback()
+		return storageError(err)
+	}
+	return nil
+}
+
+// getMetadata returns the metadata of the stream and its version, 0 if the stream has no metadata
+func (s *Service) getMetadata(_ context.Context, cmd GetMetadataCommand) (StreamMetadata, uint64, error) {
+	if _, err := s.readStreamState(s.kvs, cmd.name); err != nil {
+		return StreamMetadata{}, 0, err
+	}
+	return s.readMetadata(s.kvs, cmd.name)
+}
+
+// retained wraps deliver to skip entries not retained by their streams, neither deleted nor expired by the metadata
+// of their streams. Batches without retained entries are not delivered.
+func (s *Service) retained(deliver func([]Entry) error) func([]Entry) error {
+	return func(entries []Entry) error {
+		retains := s.retention(time.Now())
+		visible := make([]Entry, 0, len(entries))
+		for _, e := range entries {
+			ok, err := retains(e)
+			if err != nil {
+				return err
+			}
+			if ok {
+				visible = append(visible, e)
+			}
+		}
+		if len(visible) == 0 {
+			return nil
+		}
+		return deliver(visible)
+	}
+}
+
+// hideUnretained removes the events of the entries not retained by their streams, as the scavenger eventually does
+// in the global log. The entries stay in place, so the global positions remain gapless.
+func (s *Service) hideUnretained(entries []Entry) error {
+	retains := s.retention(time.Now())
+	for i, e := range entries {
+		ok, err := retains(e)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			entries[i].Event = nil
+		}
+	}
+	return nil
+}
+
+// retention returns a function reporting whether the event of an entry is retained at the given time, reading the
+// retention of each stream once
+func (s *Service) retention(now time.Time) func(e Entry) (bool, error) {
+	retentions := make(map[string]*streamRetention)
+	return func(e Entry) (bool, error) {
+		r, ok := retentions[e.Stream]
+		if !ok {
+			var err error
+			if r, err = s.readRetention(s.kvs, e.Stream); err != nil {
+				return false, err
+			}
+			retentions[e.Stream] = r
+		}
+		return r.retains(e, now), nil
+	}
+}
+
+// readMetadata returns the metadata of the stream and its version, 0 if the stream has no metadata
+func (s *Service) readMetadata(rw kvstore.ReadWriter, stream string) (StreamMetadata, uint64, error) {
+	raw, err := rw.Get(kvstore.KvsBucketMeta, stream)
+	if errors.Is(err, kvstore.ErrNotFound) {
+		return StreamMetadata{}, 0, nil
+	}
+	if err != nil {
+		return StreamMetadata{}, 0, storageError(err)
+	}
+	var stored storedMetadata
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		return StreamMetadata{}, 0, wrapError(ErrReadStreamFailed, err, "invalid metadata of stream <%s>", stream)
+	}
+	return StreamMetadata{
+		MaxCount:       stored.MaxCount,
+		MaxAge:         stored.MaxAge,
+		TruncateBefore: stored.TruncateBefore,
+		scavenged:      stored.Scavenged,
+	}, stored.Version, nil
+}
+
+func (s *Service) writeMetadata(rw kvstore.ReadWriter, stream string, version uint64, m StreamMetadata) error {
+	raw, err := json.Marshal(storedMetadata{
+		Version:        version,
+		MaxCount:       m.MaxCount,
+		MaxAge:         m.MaxAge,
+		TruncateBefore: m.TruncateBefore,
+		Scavenged:      m.scavenged,
+	})
+	if err != nil {
+		return err
+	}
+	return rw.Put(kvstore.KvsBucketMeta, stream, raw)
+}
+
+// metadataStream returns the name under which the metadata of the stream is reported in errors
+func metadataStream(stream string) string {
+	return "$$" + stream
+}
@@ -15,12 +15,20 @@ func (n noopKVs) Put(bucket, key string, value []byte) error {
 }
 
 func (n noopKVs) Get(bucket, key string) ([]byte, error) {
-	return make([]byte, 0), nil
+	return nil, kvstore.ErrNotFound
 }
 
-func (n noopKVs) WithTx(fn ...func() error) error {
+func (n noopKVs) Delete(bucket, key string) error {
+	return nil
+}
+
+func (n noopKVs) Scan(bucket, prefix, cursor string, limit int) ([]kvstore.KeyValue, string, error) {
+	return make([]kvstore.KeyValue, 0), "", nil
+}
+
+func (n noopKVs) WithTx(fn ...func(tx kvstore.ReadWriter) error) error {
 	for _, f := range fn {
-		if err := f(); err != nil {
+		if err := f(n); err != nil {
 			return err
 		}
 	}
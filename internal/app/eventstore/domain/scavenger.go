@@ -0,0 +1,217 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+)
+
+// scavengeBatchSize is the count of entries of the global log scavenged within one transaction
+const scavengeBatchSize = 1000
+
+// ScavengeProgress reports the progress of a scavenge run
+type ScavengeProgress struct {
+	Position       uint64 // global position scavenged up to
+	Head           uint64 // global position of the last entry when the run started
+	RemovedEvents  uint64
+	FreedBytes     uint64 // bytes of removed events
+	CompactedBytes uint64 // bytes freed by compacting the key-value-store
+	Done           bool
+}
+
+// streamRetention decides which events of a stream are removed by the scavenger
+type streamRetention struct {
+	tombstone bool
+	first     uint64 // 0-based position of the first event neither deleted nor truncated
+	metadata  StreamMetadata
+	version   uint64 // version of the metadata, 0 if the stream has no metadata
+	retained  bool   // an event of the stream was retained, so no later expired event is removed
+	expired   bool   // expired events were removed, so the scavenged position of the metadata advanced
+}
+
+// ScavengePeriodically scavenges the storage in the interval until the context is done
+func (s *Service) ScavengePeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.scavenge(ctx, Scavenge(func(ScavengeProgress) error { return nil })); err != nil {
+				log.Printf("[WARN]\t %T.ScavengePeriodically - scavenge failed: %s", s, err)
+			}
+		}
+	}
+}
+
+// scavenge walks the global log and removes the events not retained by their streams from the content of the
+// streams and from the global log, whose entries are kept without event. Afterwards the key-value-store is compacted
+// if it supports compaction.
+func (s *Service) scavenge(ctx context.Context, cmd ScavengeCommand) error {
+	if !s.scavenging.TryLock() {
+		return newError(ErrScavengeRunning, "scavenge already running")
+	}
+	defer s.scavenging.Unlock()
+	progress := ScavengeProgress{Head: s.head()}
+	log.Printf("[INFO]\t %T.scavenge - scavenge global log up to position %d", s, progress.Head)
+	retained := make(map[string]bool)
+	for progress.Position < progress.Head {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		to := min(progress.Head, progress.Position+scavengeBatchSize)
+		removed, freed, err := s.scavengeBatch(progress.Position+1, to, retained)
+		if err != nil {
+			return err
+		}
+		progress.Position, progress.RemovedEvents, progress.FreedBytes = to, progress.RemovedEvents+removed, progress.FreedBytes+freed
+		log.Printf("[DEBUG]\t %T.scavenge - scavenged up to position %d: %d events removed", s, to, progress.RemovedEvents)
+		if err := cmd.progress(progress); err != nil {
+			return err
+		}
+	}
+	if c, ok := s.kvs.(kvstore.Compactor); ok {
+		compacted, err := c.Compact()
+		if err != nil {
+			return storageError(err)
+		}
+		progress.CompactedBytes = uint64(max(compacted, 0))
+	}
+	progress.Done = true
+	log.Printf("[INFO]\t %T.scavenge - %d events removed, %d bytes freed, %d bytes compacted", s,
+		progress.RemovedEvents, progress.FreedBytes, progress.CompactedBytes)
+	return cmd.progress(progress)
+}
+
+// scavengeBatch removes the events not retained from the global log in the range [from, to] and returns the count of
+// removed events and freed bytes. Streams with a retained event are marked as retained.
+func (s *Service) scavengeBatch(from, to uint64, retained map[string]bool) (uint64, uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+	var removed, freed uint64
+	now := time.Now()
+	retentions := make(map[string]*streamRetention)
+	if err := s.kvs.WithTx(func(rw kvstore.ReadWriter) error {
+		removed, freed = 0, 0
+		for pos := from; pos <= to; pos++ {
+			raw, err := rw.Get(kvstore.KvsBucketLog, logKey(pos))
+			if err != nil {
+				return wrapError(ErrReadStreamFailed, err, "read global log at position %d failed", pos)
+			}
+			record, err := decompress(raw)
+			if err != nil {
+				return err
+			}
+			var e Entry
+			if err := json.Unmarshal(record, &e); err != nil {
+				return err
+			}
+			if e.Event == nil {
+				continue
+			}
+			r, ok := retentions[e.Stream]
+			if !ok {
+				if r, err = s.readRetention(rw, e.Stream); err != nil {
+					return err
+				}
+				r.retained = retained[e.Stream]
+				retentions[e.Stream] = r
+			}
+			if !r.removes(e, now) {
+				retained[e.Stream] = true
+				continue
+			}
+			n, err := s.removeEvent(rw, e, len(raw))
+			if err != nil {
+				return err
+			}
+			removed, freed = removed+1, freed+n
+		}
+		for stream, r := range retentions {
+			if r.expired {
+				if err := s.writeMetadata(rw, stream, r.version, r.metadata); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		s.kvs.Rollback()
+		return 0, 0, storageError(err)
+	}
+	return removed, freed, nil
+}
+
+// removeEvent deletes the content of the event and its copy in the global log and returns the count of freed bytes
+func (s *Service) removeEvent(rw kvstore.ReadWriter, e Entry, logSize int) (uint64, error) {
+	var freed uint64
+	content, err := rw.Get(kvstore.KvsBucketContent, eventKey(e.Stream, e.StreamPos))
+	switch {
+	case err == nil:
+		if err := rw.Delete(kvstore.KvsBucketContent, eventKey(e.Stream, e.StreamPos)); err != nil {
+			return 0, err
+		}
+		freed += uint64(len(content))
+	case !errors.Is(err, kvstore.ErrNotFound):
+		return 0, err
+	}
+	e.Event = nil
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	raw = s.compress(raw)
+	if err := rw.Put(kvstore.KvsBucketLog, logKey(e.GlobalPos), raw); err != nil {
+		return 0, err
+	}
+	return freed + uint64(max(logSize-len(raw), 0)), nil
+}
+
+// readRetention returns the retention of the stream by its deletion and its metadata
+func (s *Service) readRetention(rw kvstore.ReadWriter, stream string) (*streamRetention, error) {
+	d, err := s.readDeletion(rw, stream)
+	if err != nil {
+		return nil, err
+	}
+	if d != nil && d.tombstone {
+		return &streamRetention{tombstone: true}, nil
+	}
+	version, err := s.readVersion(rw, stream)
+	if err != nil && ErrorCode(err) != ErrStreamNotFound {
+		return nil, err
+	}
+	metadata, metadataVersion, err := s.readMetadata(rw, stream)
+	if err != nil {
+		return nil, err
+	}
+	r := &streamRetention{first: metadata.first(version), metadata: metadata, version: metadataVersion}
+	if d != nil {
+		r.first = max(r.first, d.version)
+	}
+	return r, nil
+}
+
+// retains reports whether reads and subscriptions deliver the event of the entry
+func (r *streamRetention) retains(e Entry, now time.Time) bool {
+	return !r.tombstone && e.StreamPos > r.first && r.metadata.retains(e.Event, now)
+}
+
+// removes reports whether the scavenger removes the event of the entry. Expired events are only removed up to the
+// first retained event of the stream, so the removed events form a prefix of the stream.
+func (r *streamRetention) removes(e Entry, now time.Time) bool {
+	switch {
+	case r.tombstone || e.StreamPos <= r.first:
+		return true
+	case !r.retained && !r.metadata.retains(e.Event, now):
+		r.metadata.scavenged, r.expired = e.StreamPos, true
+		return true
+	default:
+		r.retained = true
+		return false
+	}
+}
@@ -0,0 +1,278 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// SchemaFormat is the format of the definition of a schema
+type SchemaFormat uint8
+
+const (
+	JSONSchema SchemaFormat = iota // JSON Schema validating JSON payloads
+	Protobuf                       // serialized FileDescriptorSet validating payloads encoded as protobuf message
+)
+
+func (f SchemaFormat) String() string {
+	switch f {
+	case JSONSchema:
+		return "json-schema"
+	case Protobuf:
+		return "protobuf"
+	default:
+		return fmt.Sprintf("unknown(%d)", f)
+	}
+}
+
+// Schema validates the payloads of the events with the name. Events are validated against the latest version of
+// the schema registered for their name, events without schema aren't validated.
+type Schema struct {
+	EventName    string
+	Version      uint64
+	Format       SchemaFormat
+	Definition   []byte
+	MessageName  string // full name of the message validating protobuf payloads
+	RegisteredAt time.Time
+}
+
+// validator checks payloads against a compiled schema
+type validator interface {
+	validate(payload []byte) error
+}
+
+// compiledSchema caches the validator of a version of a schema
+type compiledSchema struct {
+	version   uint64
+	validator validator
+}
+
+type jsonValidator struct {
+	schema *jsonschema.Schema
+}
+
+func (v jsonValidator) validate(payload []byte) error {
+	var doc any
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return err
+	}
+	if d.More() {
+		return errors.New("invalid character after top-level value")
+	}
+	return v.schema.Validate(doc)
+}
+
+type protoValidator struct {
+	message protoreflect.MessageDescriptor
+}
+
+func (v protoValidator) validate(payload []byte) error {
+	m := dynamicpb.NewMessage(v.message)
+	if err := proto.Unmarshal(payload, m); err != nil {
+		return err
+	}
+	return rejectUnknownFields(m)
+}
+
+// rejectUnknownFields fails if the message or one of its nested messages holds fields not defined by the schema
+func rejectUnknownFields(m protoreflect.Message) error {
+	if len(m.GetUnknown()) > 0 {
+		return fmt.Errorf("unknown fields in message %s", m.Descriptor().FullName())
+	}
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			for i := 0; i < value.List().Len() && err == nil; i++ {
+				err = rejectUnknownFields(value.List().Get(i).Message())
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			value.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+				err = rejectUnknownFields(v.Message())
+				return err == nil
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			err = rejectUnknownFields(value.Message())
+		}
+		return err == nil
+	})
+	return err
+}
+
+// compileSchema returns the validator of the schema or an error if its definition is invalid
+func compileSchema(schema Schema) (validator, error) {
+	switch schema.Format {
+	case JSONSchema:
+		c := jsonschema.NewCompiler()
+		// schemas are registered by clients, so they must not make the server load files or fetch URLs
+		c.LoadURL = func(url string) (io.ReadCloser, error) {
+			return nil, fmt.Errorf("external reference <%s> not supported", url)
+		}
+		url := "schema:///" + schema.EventName
+		if err := c.AddResource(url, bytes.NewReader(schema.Definition)); err != nil {
+			return nil, err
+		}
+		compiled, err := c.Compile(url)
+		if err != nil {
+			return nil, err
+		}
+		return jsonValidator{schema: compiled}, nil
+	case Protobuf:
+		var set descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(schema.Definition, &set); err != nil {
+			return nil, err
+		}
+		files, err := protodesc.NewFiles(&set)
+		if err != nil {
+			return nil, err
+		}
+		desc, err := files.FindDescriptorByName(protoreflect.FullName(schema.MessageName))
+		if err != nil {
+			return nil, err
+		}
+		message, ok := desc.(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("%s is no message", schema.MessageName)
+		}
+		return protoValidator{message: message}, nil
+	default:
+		return nil, fmt.Errorf("unknown schema format <%d>", schema.Format)
+	}
+}
+
+func (s *Service) registerSchema(_ context.Context, cmd RegisterSchemaCommand) error {
+	schema := cmd.schema
+	if schema.EventName == "" {
+		return newError(ErrInvalidRequest, "schema without event name")
+	}
+	if _, err := compileSchema(schema); err != nil {
+		return wrapError(ErrInvalidRequest, err, "invalid %s schema of event <%s>", schema.Format, schema.EventName)
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := s.kvs.WithTx(func(rw kvstore.ReadWriter) error {
+		schemas, err := s.readSchemas(rw, schema.EventName)
+		if err != nil {
+			return err
+		}
+		version := uint64(len(schemas))
+		if !cmd.mode.accepts(cmd.expectedVersion, version, version > 0) {
+			return &VersionMismatchError{Stream: schemaStream(schema.EventName), Mode: cmd.mode,
+				Expected: cmd.expectedVersion, Actual: version}
+		}
+		schema.Version, schema.RegisteredAt = version+1, time.Now().UTC()
+		log.Printf("[INFO]\t %T.registerSchema - register %s schema version %d of event <%s>", s,
+			schema.Format, schema.Version, schema.EventName)
+		raw, err := json.Marshal(append(schemas, schema))
+		if err != nil {
+			return err
+		}
+		return rw.Put(kvstore.KvsBucketSchemas, schema.EventName, raw)
+	}); err != nil {
+		s.kvs.Rollback()
+		return storageError(err)
+	}
+	delete(s.validators, schema.EventName)
+	return nil
+}
+
+// listSchemas returns all versions of the schema of the event name or the latest versions of all schemas if the
+// command names no event
+func (s *Service) listSchemas(_ context.Context, cmd ListSchemasCommand) ([]Schema, error) {
+	if cmd.eventName != "" {
+		return s.readSchemas(s.kvs, cmd.eventName)
+	}
+	kvs, _, err := s.kvs.Scan(kvstore.KvsBucketSchemas, "", "", 0)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	result := make([]Schema, 0, len(kvs))
+	for _, kv := range kvs {
+		var schemas []Schema
+		if err := json.Unmarshal(kv.Value, &schemas); err != nil {
+			return result, wrapError(ErrReadStreamFailed, err, "invalid schemas of event <%s>", kv.Key)
+		}
+		if len(schemas) > 0 {
+			result = append(result, schemas[len(schemas)-1])
+		}
+	}
+	return result, nil
+}
+
+// validateEvents checks the payloads of the events against the latest schemas registered for their names. The
+// caller must hold the lock of the service.
+func (s *Service) validateEvents(rw kvstore.ReadWriter, stream string, events []*Event) error {
+	for _, e := range events {
+		compiled, err := s.validatorOf(rw, e.Name())
+		if err != nil {
+			return err
+		}
+		if compiled == nil {
+			continue
+		}
+		if err := compiled.validator.validate(e.Payload()); err != nil {
+			return &SchemaViolationError{Stream: stream, EventID: e.ID(), EventName: e.Name(),
+				SchemaVersion: compiled.version, Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+// validatorOf returns the cached validator of the latest schema of the event name, nil if no schema is registered.
+// The caller must hold the lock of the service.
+func (s *Service) validatorOf(rw kvstore.ReadWriter, eventName string) (*compiledSchema, error) {
+	if compiled, ok := s.validators[eventName]; ok {
+		return compiled, nil
+	}
+	schemas, err := s.readSchemas(rw, eventName)
+	if err != nil {
+		return nil, err
+	}
+	var compiled *compiledSchema
+	if len(schemas) > 0 {
+		latest := schemas[len(schemas)-1]
+		v, err := compileSchema(latest)
+		if err != nil {
+			return nil, wrapError(ErrReadStreamFailed, err, "invalid schema version %d of event <%s>", latest.Version, eventName)
+		}
+		compiled = &compiledSchema{version: latest.Version, validator: v}
+	}
+	s.validators[eventName] = compiled
+	return compiled, nil
+}
+
+// readSchemas returns all versions of the schema of the event name in the order they were registered
+func (s *Service) readSchemas(rw kvstore.ReadWriter, eventName string) ([]Schema, error) {
+	raw, err := rw.Get(kvstore.KvsBucketSchemas, eventName)
+	if errors.Is(err, kvstore.ErrNotFound) {
+		return make([]Schema, 0), nil
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	var schemas []Schema
+	if err := json.Unmarshal(raw, &schemas); err != nil {
+		return nil, wrapError(ErrReadStreamFailed, err, "invalid schemas of event <%s>", eventName)
+	}
+	return schemas, nil
+}
+
+// schemaStream returns the name under which the schema of the event name is reported in errors
+func schemaStream(eventName string) string {
+	return "$schema-" + eventName
+}
@@ -3,25 +3,49 @@ package domain
 import (
 	"context"
 	"encoding/binary"
-	"fmt"
+	"errors"
 	"log"
+	"slices"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/openyard/eventstore/internal/app/kvstore"
 )
 
+// defaultReadAllCount is the max count of entries returned by readAll if the request provides no max count
+const defaultReadAllCount = 1000
+
 type ServiceOpts func(*Service)
 
 type Service struct {
-	kvs kvstore.KeyValueStore
+	sync.Mutex  // serializes appends so entries are published in commit order
+	kvs         kvstore.KeyValueStore
+	broker      *broker
+	position    uint64                     // global position of the last committed entry
+	acks        sync.Mutex                 // serializes acknowledgements of persistent subscriptions
+	scavenging  sync.Mutex                 // held while scavenging, so only one scavenge runs at a time
+	validators  map[string]*compiledSchema // latest schema per event name, nil without schema, guarded by the lock
+	upcasters   map[string]Upcaster        // upcaster per event name, see WithUpcaster
+	compression Compression                // compression of the records written to the content bucket and the log
 }
 
-func NewService(opts ...ServiceOpts) *Service {
-	s := &Service{kvs: &noopKVs{}}
+// NewService returns the service on the key-value-store set by the options. It fails if the position of the global
+// log can't be recovered from the store.
+func NewService(opts ...ServiceOpts) (*Service, error) {
+	s := &Service{
+		kvs:        &noopKVs{},
+		broker:     newBroker(defaultSubscriptionBufferSize),
+		validators: make(map[string]*compiledSchema),
+		upcasters:  make(map[string]Upcaster),
+	}
 	for _, opt := range opts {
 		opt(s)
 	}
-	return s
+	if err := s.recoverPosition(); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 func WithKeyValueStore(kvs kvstore.KeyValueStore) ServiceOpts {
@@ -30,18 +54,37 @@ func WithKeyValueStore(kvs kvstore.KeyValueStore) ServiceOpts {
 	}
 }
 
+// WithSubscriptionBufferSize sets the max count of entries buffered per subscriber before it gets dropped
+func WithSubscriptionBufferSize(size int) ServiceOpts {
+	return func(s *Service) {
+		s.broker = newBroker(size)
+	}
+}
+
 func (s *Service) HandleFunc(cmd Command) error {
 	switch cmd.kind {
 	case AppendCmd:
 		return s.append(cmd.ctx, cmd.payload.(AppendCommand))
 	case SubscribeCmd:
-		// ...
-		return nil
+		return s.subscribe(cmd.ctx, cmd.payload.(SubscribeCommand))
+	case SubscribeWithIDCmd:
+		return s.subscribeWithID(cmd.ctx, cmd.payload.(SubscribeWithIDCommand))
 	case SubscribeWithOffsetCmd:
-		// ...
-		return nil
+		return s.subscribeWithOffset(cmd.ctx, cmd.payload.(SubscribeWithOffsetCommand))
+	case AckCmd:
+		return s.ack(cmd.ctx, cmd.payload.(AckCommand))
+	case DeleteCmd:
+		return s.deleteStream(cmd.ctx, cmd.payload.(DeleteCommand))
+	case SetMetadataCmd:
+		return s.setMetadata(cmd.ctx, cmd.payload.(SetMetadataCommand))
+	case ScavengeCmd:
+		return s.scavenge(cmd.ctx, cmd.payload.(ScavengeCommand))
+	case RegisterSchemaCmd:
+		return s.registerSchema(cmd.ctx, cmd.payload.(RegisterSchemaCommand))
+	case ShredAggregateCmd:
+		return s.shredAggregate(cmd.ctx, cmd.payload.(ShredAggregateCommand))
 	default:
-		return fmt.Errorf("unknown command: <%v>", cmd.kind)
+		return newError(ErrInvalidRequest, "unknown command: <%v>", cmd.kind)
 	}
 }
 
@@ -52,90 +95,289 @@ func (s *Service) QueryFunc(cmd Command) ([]Stream, error) {
 	case ReadAtCmd:
 		return s.readAt(cmd.ctx, cmd.payload.(ReadAtCommand))
 	default:
-		return nil, fmt.Errorf("unknown command: <%v>", cmd.kind)
+		return nil, newError(ErrInvalidRequest, "unknown command: <%v>", cmd.kind)
+	}
+}
+
+func (s *Service) QueryEntriesFunc(cmd Command) ([]Entry, error) {
+	switch cmd.kind {
+	case ReadAllCmd:
+		return s.readAll(cmd.ctx, cmd.payload.(ReadAllCommand))
+	default:
+		return nil, newError(ErrInvalidRequest, "unknown command: <%v>", cmd.kind)
+	}
+}
+
+// QueryMetadataFunc answers queries for the metadata of a stream with the metadata and its version
+func (s *Service) QueryMetadataFunc(cmd Command) (StreamMetadata, uint64, error) {
+	switch cmd.kind {
+	case GetMetadataCmd:
+		return s.getMetadata(cmd.ctx, cmd.payload.(GetMetadataCommand))
+	default:
+		return StreamMetadata{}, 0, newError(ErrInvalidRequest, "unknown command: <%v>", cmd.kind)
+	}
+}
+
+// QueryStreamsFunc answers queries listing streams with a page of streams and the token continuing the listing,
+// which is empty on the last page
+func (s *Service) QueryStreamsFunc(cmd Command) ([]StreamInfo, string, error) {
+	switch cmd.kind {
+	case ListStreamsCmd:
+		return s.listStreams(cmd.ctx, cmd.payload.(ListStreamsCommand))
+	default:
+		return nil, "", newError(ErrInvalidRequest, "unknown command: <%v>", cmd.kind)
+	}
+}
+
+// QuerySchemasFunc answers queries for the schemas validating the payloads of events
+func (s *Service) QuerySchemasFunc(cmd Command) ([]Schema, error) {
+	switch cmd.kind {
+	case ListSchemasCmd:
+		return s.listSchemas(cmd.ctx, cmd.payload.(ListSchemasCommand))
+	default:
+		return nil, newError(ErrInvalidRequest, "unknown command: <%v>", cmd.kind)
 	}
 }
 
 func (s *Service) append(_ context.Context, cmd AppendCommand) error {
-	if err := s.kvs.WithTx(func() error {
+	s.Lock()
+	defer s.Unlock()
+	var entries []Entry
+	if err := s.kvs.WithTx(func(rw kvstore.ReadWriter) error {
+		entries = make([]Entry, 0)
 		for _, streamData := range cmd.streamData {
-			version, err := s.kvs.Get(kvstore.KvsBucketIndex, streamData.name)
-			if err != nil && streamData.expectedVersion > 0 {
-				log.Printf("[ERROR]\t %T.append - read index failed: %s", s, err)
-				return err
+			if streamData.name == "" || streamData.name == allStreamName {
+				return newError(ErrInvalidRequest, "invalid stream name <%s>", streamData.name)
 			}
-			if binary.BigEndian.Uint64(version) != streamData.expectedVersion {
-				log.Printf("[ERROR]\t %T.append - concurrent write mismatch index: %d(actual) != %d(expected)", s, binary.BigEndian.Uint64(version), streamData.expectedVersion)
-				return fmt.Errorf("[%4d] concurrent write mismatch index: %d(actual) != %d(expected)",
-					ErrConcurrentChange, binary.BigEndian.Uint64(version), streamData.expectedVersion)
+			if streamData.mode > StreamExists {
+				return newError(ErrInvalidRequest, "invalid expected version mode <%d> of stream <%s>", streamData.mode, streamData.name)
 			}
-			var stream *Stream
-			if streamData.expectedVersion == 0 {
-				stream = s.firstAppend(streamData)
-			} else if stream, err = s.nextAppend(streamData); err != nil {
+			state, err := s.readStreamState(rw, streamData.name)
+			if err != nil {
+				log.Printf("[ERROR]\t %T.append - read index failed: %s", s, err)
 				return err
 			}
-
-			log.Printf("[DEBUG]\t %T.append - entries: %+v", s, stream.events)
-			var raw []byte
-			raw, err = stream.MarshalJSON()
-			if err != nil {
-				log.Printf("[ERROR]\t %T.append - marshaling error: %s", s, err)
+			version := state.version
+			accepted := streamData.mode.accepts(streamData.expectedVersion, version, state.exists)
+			if err := s.migrate(rw, streamData.name, version); err != nil {
 				return err
 			}
-			log.Printf("[TRACE]\t %T.append - raw stream: %s", s, string(raw))
-			idx := make([]byte, 8)
-			binary.BigEndian.PutUint64(idx, stream.version)
-			if err := s.kvs.Put(kvstore.KvsBucketContent, stream.name, raw); err != nil {
+			if !accepted || streamData.mode == Any || streamData.mode == StreamExists {
+				written, err := s.alreadyWritten(rw, streamData, state)
+				if err != nil {
+					return err
+				}
+				if written {
+					log.Printf("[DEBUG]\t %T.append - %d events already written to stream <%s>", s, len(streamData.events), streamData.name)
+					continue
+				}
+			}
+			if !accepted {
+				log.Printf("[ERROR]\t %T.append - concurrent write mismatch index: %d(actual) != %d(expected, %s)",
+					s, version, streamData.expectedVersion, streamData.mode)
+				return &VersionMismatchError{Stream: streamData.name, Mode: streamData.mode,
+					Expected: streamData.expectedVersion, Actual: version}
+			}
+			if err := s.validateEvents(rw, streamData.name, streamData.events); err != nil {
+				log.Printf("[ERROR]\t %T.append - %s", s, err)
 				return err
 			}
-			if err := s.kvs.Put(kvstore.KvsBucketIndex, stream.name, idx); err != nil {
+			events := streamData.events
+			if version == 0 {
+				sort.SliceStable(events, func(i, j int) bool {
+					return events[i].OccurredAt().Before(events[j].OccurredAt())
+				})
+			}
+			log.Printf("[DEBUG]\t %T.append - %d events to stream <%s>", s, len(events), streamData.name)
+			if err := s.writeEvents(rw, streamData.name, version, events); err != nil {
 				return err
 			}
+			for i, e := range events {
+				entries = append(entries, Entry{
+					GlobalPos: s.position + uint64(len(entries)) + 1,
+					Stream:    streamData.name,
+					StreamPos: version + uint64(i) + 1,
+					Event:     e,
+				})
+			}
 		}
-		return nil
+		return s.writeLog(rw, entries)
 	}); err != nil {
 		s.kvs.Rollback()
-		return err
+		return storageError(err)
 	}
+	s.position += uint64(len(entries))
+	s.broker.publish(entries)
 	return nil
 }
 
+func (s *Service) subscribe(ctx context.Context, cmd SubscribeCommand) error {
+	sub := s.broker.subscribe()
+	defer s.broker.unsubscribe(sub)
+	_, dropped, err := s.deliverLive(ctx, sub, 0, subscriptionLimit(cmd.limit), s.retained(s.upcasted(cmd.deliver)))
+	if dropped {
+		log.Printf("[WARN]\t %T.subscribe - subscriber dropped: buffer of %d entries exceeded", s, s.broker.bufferSize)
+		return newError(ErrSubscriptionDropped, "subscription dropped: consumer too slow")
+	}
+	return err
+}
+
+// subscribeWithOffset delivers all entries of the global log starting at the offset and switches over to live
+// delivery once the subscriber caught up. A subscriber dropped for being too slow falls back to catching up.
+func (s *Service) subscribeWithOffset(ctx context.Context, cmd SubscribeWithOffsetCommand) error {
+	limit := subscriptionLimit(cmd.limit)
+	deliver := s.retained(s.upcasted(cmd.deliver))
+	next := max(cmd.offset, 1)
+	for {
+		sub := s.broker.subscribe()
+		var err error
+		if next, err = s.catchUp(ctx, next, limit, deliver); err != nil {
+			s.broker.unsubscribe(sub)
+			return err
+		}
+		var dropped bool
+		next, dropped, err = s.deliverLive(ctx, sub, next, limit, deliver)
+		s.broker.unsubscribe(sub)
+		if err != nil || !dropped {
+			return err
+		}
+		log.Printf("[WARN]\t %T.subscribeWithOffset - subscriber dropped at position %d: catching up", s, next)
+	}
+}
+
+// subscribeWithID resumes the subscription after the last acknowledged position of the subscription
+func (s *Service) subscribeWithID(ctx context.Context, cmd SubscribeWithIDCommand) error {
+	if cmd.subscriptionID == "" {
+		return newError(ErrInvalidRequest, "subscription id must not be empty")
+	}
+	acked, err := s.acked(cmd.subscriptionID)
+	if err != nil {
+		return err
+	}
+	log.Printf("[DEBUG]\t %T.subscribeWithID - resume subscription <%s> after position %d", s, cmd.subscriptionID, acked)
+	return s.subscribeWithOffset(ctx, SubscribeWithOffset(acked+1, cmd.limit, cmd.deliver))
+}
+
+// ack stores the position as last acknowledged position of the subscription. Positions never move backwards and
+// must not exceed the head of the global log.
+func (s *Service) ack(_ context.Context, cmd AckCommand) error {
+	if cmd.subscriptionID == "" {
+		return newError(ErrInvalidRequest, "subscription id must not be empty")
+	}
+	if head := s.head(); cmd.position > head {
+		return newError(ErrInvalidRequest, "ack of position %d beyond head %d of the global log", cmd.position, head)
+	}
+	s.acks.Lock()
+	defer s.acks.Unlock()
+	acked, err := s.acked(cmd.subscriptionID)
+	if err != nil {
+		return err
+	}
+	if cmd.position <= acked {
+		return nil
+	}
+	pos := make([]byte, 8)
+	binary.BigEndian.PutUint64(pos, cmd.position)
+	return storageError(s.kvs.Put(kvstore.KvsBucketSubscriptions, cmd.subscriptionID, pos))
+}
+
+// acked returns the last acknowledged position of the subscription or 0 for unknown subscriptions
+func (s *Service) acked(subscriptionID string) (uint64, error) {
+	if err := s.kvs.AssertBucket(kvstore.KvsBucketSubscriptions); err != nil {
+		return 0, storageError(err)
+	}
+	raw, err := s.kvs.Get(kvstore.KvsBucketSubscriptions, subscriptionID)
+	if errors.Is(err, kvstore.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, storageError(err)
+	}
+	if len(raw) != 8 {
+		return 0, newError(ErrReadStreamFailed, "invalid position of subscription <%s>", subscriptionID)
+	}
+	return binary.BigEndian.Uint64(raw), nil
+}
+
+// catchUp delivers the global log from position next up to the current head in batches of limit and returns the
+// position of the next entry to deliver
+func (s *Service) catchUp(ctx context.Context, next uint64, limit int, deliver func([]Entry) error) (uint64, error) {
+	for head := s.head(); next <= head; head = s.head() {
+		if ctx.Err() != nil {
+			return next, nil
+		}
+		entries, err := s.readLog(next, min(head, next+uint64(limit)-1))
+		if err != nil {
+			return next, err
+		}
+		if err := deliver(entries); err != nil {
+			return next, err
+		}
+		next += uint64(len(entries))
+	}
+	return next, nil
+}
+
 func (s *Service) read(_ context.Context, cmd ReadCommand) ([]Stream, error) {
 	result := make([]Stream, 0)
 	for _, stream := range cmd.streams {
-		streamData, err := s.kvs.Get(kvstore.KvsBucketContent, stream)
+		elem, err := s.loadStream(stream, func(first, version uint64) ([]uint64, bool) {
+			return pageRange(first, version, cmd.from, cmd.maxCount, cmd.direction)
+		})
 		if err != nil {
 			return result, err
 		}
-		var elem Stream
-		if err := elem.UnmarshalJSON(streamData); err != nil {
-			return result, err
+		elem.direction = cmd.direction
+		result = append(result, *elem)
+	}
+	return result, nil
+}
+
+func (s *Service) readAll(_ context.Context, cmd ReadAllCommand) ([]Entry, error) {
+	count := uint64(cmd.maxCount)
+	if count == 0 {
+		count = defaultReadAllCount
+	}
+	head := s.head()
+	if cmd.direction == Forward {
+		from := max(cmd.position, 1)
+		entries, err := s.readLog(from, min(head, from+count-1))
+		if err != nil {
+			return entries, err
 		}
-		if uint64(len(elem.events)) != elem.version {
-			return result, fmt.Errorf("[ERROR]\t %T.read !!! version mismatch in stream <%s>: version=%d, events=%d",
-				s, elem.name, elem.version, len(elem.events))
+		if err := s.hideUnretained(entries); err != nil {
+			return entries, err
 		}
-		result = append(result, elem)
+		return s.upcastEntries(entries)
 	}
-	return result, nil
+	from := cmd.position
+	if from == 0 || from > head {
+		from = head
+	}
+	to := uint64(1)
+	if from > count {
+		to = from - count + 1
+	}
+	entries, err := s.readLog(to, from)
+	if err != nil {
+		return entries, err
+	}
+	if err := s.hideUnretained(entries); err != nil {
+		return entries, err
+	}
+	slices.Reverse(entries)
+	return s.upcastEntries(entries)
 }
 
 func (s *Service) readAt(_ context.Context, cmd ReadAtCommand) ([]Stream, error) {
 	result := make([]Stream, 0)
 	for _, stream := range cmd.streams {
-		streamData, err := s.kvs.Get(kvstore.KvsBucketContent, stream)
+		elem, err := s.loadStream(stream, func(first, version uint64) ([]uint64, bool) {
+			return pageRange(first, version, 0, 0, Forward)
+		})
 		if err != nil {
 			return result, err
 		}
-		var elem Stream
-		if err := elem.UnmarshalJSON(streamData); err != nil {
-			return result, err
-		}
-		if uint64(len(elem.events)) != elem.version {
-			return result, fmt.Errorf("[ERROR]\t %T.readAt !!! version mismatch in stream <%s>: version=%d, events=%d",
-				s, elem.name, elem.version, len(elem.events))
-		}
 		ev := make(map[uint64]*Event)
 		for i, e := range elem.events {
 			if !e.OccurredAt().After(cmd.at) {
@@ -143,35 +385,69 @@ func (s *Service) readAt(_ context.Context, cmd ReadAtCommand) ([]Stream, error)
 			}
 		}
 		elem.events = ev
-		result = append(result, elem)
+		result = append(result, *elem)
 	}
 	return result, nil
 }
 
-func (s *Service) firstAppend(streamData StreamData) *Stream {
-	sort.Slice(streamData.events, func(i, j int) bool {
-		return streamData.events[i].OccurredAt().Before(streamData.events[j].OccurredAt())
-	})
-	entries := make(map[uint64]*Event, len(streamData.events))
-	for idx, e := range streamData.events {
-		entries[uint64(idx)] = e
+// loadStream loads the events retained at the positions selected for the current version of the stream, the
+// selected positions must not precede the 0-based position first of the first event neither deleted nor truncated
+func (s *Service) loadStream(name string, selectPositions func(first, version uint64) ([]uint64, bool)) (*Stream, error) {
+	state, err := s.readStreamState(s.kvs, name)
+	if err != nil {
+		return nil, err
 	}
-	return buildStream(streamData.name, uint64(len(entries)), entries)
-}
-
-func (s *Service) nextAppend(streamData StreamData) (*Stream, error) {
-	raw, err := s.kvs.Get(kvstore.KvsBucketContent, streamData.name)
-	if err != nil && streamData.expectedVersion > 0 {
+	if !state.exists {
+		return nil, &StreamNotFoundError{Stream: name}
+	}
+	if err := s.assertMigrated(name, state.version); err != nil {
+		return nil, err
+	}
+	meta, _, err := s.readMetadata(s.kvs, name)
+	if err != nil {
 		return nil, err
 	}
-	var stream Stream
-	if err = stream.UnmarshalJSON(raw); err != nil {
+	positions, endOfStream := selectPositions(max(state.first, meta.first(state.version)), state.version)
+	events, err := s.readEvents(s.kvs, name, positions)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for pos, e := range events {
+		if !meta.retains(e, now) {
+			delete(events, pos)
+		}
+	}
+	if err := s.upcastEvents(events); err != nil {
 		return nil, err
 	}
-	log.Printf("current stream: %s", &stream)
-	for idx, e := range streamData.events {
-		stream.events[stream.version+uint64(idx)] = e
+	stream := buildStream(name, state.version, events)
+	stream.endOfStream = endOfStream
+	return stream, nil
+}
+
+// deliverLive delivers the entries published to the subscriber in batches of limit, skipping entries before
+// position next. It returns the position of the next entry to deliver and whether the subscriber got dropped.
+func (s *Service) deliverLive(ctx context.Context, sub *subscriber, next uint64, limit int,
+	deliver func([]Entry) error) (uint64, bool, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return next, false, nil
+		case <-sub.dropped:
+			return next, true, nil
+		case e := <-sub.entries:
+			batch := sub.next(e, limit)
+			for len(batch) > 0 && batch[0].GlobalPos < next {
+				batch = batch[1:]
+			}
+			if len(batch) == 0 {
+				continue
+			}
+			if err := deliver(batch); err != nil {
+				return next, false, err
+			}
+			next = batch[len(batch)-1].GlobalPos + 1
+		}
 	}
-	stream.version += uint64(len(streamData.events))
-	return &stream, nil
 }
@@ -0,0 +1,1221 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+	"github.com/openyard/eventstore/pkg/kvstore/filekv"
+	"github.com/openyard/eventstore/pkg/kvstore/memkv"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// testBuckets are the buckets used by the service
+var testBuckets = []string{kvstore.KvsBucketIndex, kvstore.KvsBucketContent, kvstore.KvsBucketLog,
+	kvstore.KvsBucketSubscriptions, kvstore.KvsBucketDeleted, kvstore.KvsBucketMeta, kvstore.KvsBucketSchemas,
+	kvstore.KvsBucketKeys}
+
+func newTestKVS() *memkv.MemoryKVS {
+	return memkv.NewMemoryKVS(testBuckets...)
+}
+
+// newTestService returns a service on a new memory store, the options may replace it
+func newTestService(opts ...ServiceOpts) *Service {
+	s, err := NewService(append([]ServiceOpts{WithKeyValueStore(newTestKVS())}, opts...)...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// failingKVS fails the reads of a bucket with its error
+type failingKVS struct {
+	kvstore.KeyValueStore
+	bucket string
+	err    error
+}
+
+func (f *failingKVS) Get(bucket, key string) ([]byte, error) {
+	if bucket == f.bucket {
+		return nil, f.err
+	}
+	return f.KeyValueStore.Get(bucket, key)
+}
+
+func testEventsFor(aggregateID string, count int) []*Event {
+	events := make([]*Event, 0, count)
+	for i := 0; i < count; i++ {
+		events = append(events, NewEventAt(aggregateID+"-"+string(rune('a'+i)), "v1/test-event", aggregateID,
+			ts1.Add(time.Duration(i)*time.Millisecond), nil))
+	}
+	return events
+}
+
+func TestService_Subscribe(t *testing.T) {
+	s := newTestService()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan []Entry, 10)
+	done := make(chan error)
+	go func() {
+		done <- s.HandleFunc(NewCommand(ctx, SubscribeCmd, Subscribe(2, func(entries []Entry) error {
+			received <- entries
+			return nil
+		})))
+	}()
+	waitForSubscribers(t, s, 1)
+
+	if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(
+		NewStreamData("stream-1", 0, testEventsFor("stream-1", 2)...),
+		NewStreamData("stream-2", 0, testEventsFor("stream-2", 1)...),
+	))); err != nil {
+		t.Fatalf("append failed: %s", err)
+	}
+
+	var got []Entry
+	for len(got) < 3 {
+		select {
+		case batch := <-received:
+			if len(batch) > 2 {
+				t.Errorf("batch exceeds limit: %d", len(batch))
+			}
+			got = append(got, batch...)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for entries, got %d", len(got))
+		}
+	}
+	want := []struct {
+		globalPos uint64
+		stream    string
+		streamPos uint64
+	}{{1, "stream-1", 1}, {2, "stream-1", 2}, {3, "stream-2", 1}}
+	for i, w := range want {
+		if got[i].GlobalPos != w.globalPos || got[i].Stream != w.stream || got[i].StreamPos != w.streamPos {
+			t.Errorf("entry %d = %d/%s/%d, want %d/%s/%d", i,
+				got[i].GlobalPos, got[i].Stream, got[i].StreamPos, w.globalPos, w.stream, w.streamPos)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("subscription ended with error: %s", err)
+	}
+}
+
+func TestService_SubscribeDropsSlowConsumer(t *testing.T) {
+	s := newTestService()
+	s.broker = newBroker(1)
+	ctx := context.Background()
+
+	block := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- s.HandleFunc(NewCommand(ctx, SubscribeCmd, Subscribe(1, func(entries []Entry) error {
+			<-block
+			return nil
+		})))
+	}()
+	waitForSubscribers(t, s, 1)
+
+	for i, name := range []string{"stream-1", "stream-2", "stream-3"} {
+		if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(
+			NewStreamData(name, 0, testEventsFor(name, 2)...),
+		))); err != nil {
+			t.Fatalf("append %d failed: %s", i, err)
+		}
+	}
+	close(block)
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("expected subscription to be dropped")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer was not dropped")
+	}
+}
+
+func waitForSubscribers(t *testing.T, s *Service, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		s.broker.Lock()
+		count := len(s.broker.subscribers)
+		s.broker.Unlock()
+		if count == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d subscribers", n)
+}
+
+func TestService_SubscribeWithOffset(t *testing.T) {
+	s := newTestService()
+	s.broker = newBroker(2) // forces the subscriber to fall back to catching up
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	appendTestStream(t, s, "stream-1", 3)
+	appendTestStream(t, s, "stream-2", 2)
+
+	received := make(chan []Entry, 100)
+	done := make(chan error)
+	go func() {
+		done <- s.HandleFunc(NewCommand(ctx, SubscribeWithOffsetCmd, SubscribeWithOffset(2, 2, func(entries []Entry) error {
+			received <- entries
+			return nil
+		})))
+	}()
+	for i := 3; i < 8; i++ {
+		appendTestStream(t, s, "stream-"+string(rune('0'+i)), 2)
+	}
+
+	next := uint64(2)
+	for next <= 15 {
+		select {
+		case batch := <-received:
+			for _, e := range batch {
+				if e.GlobalPos != next {
+					t.Fatalf("unexpected global position %d, want %d", e.GlobalPos, next)
+				}
+				if string(e.Event.Payload()) != e.Stream {
+					t.Errorf("unexpected payload %q in entry %d", e.Event.Payload(), e.GlobalPos)
+				}
+				next++
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for entry %d", next)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("subscription ended with error: %s", err)
+	}
+}
+
+func appendTestStream(t *testing.T, s *Service, name string, count int) {
+	t.Helper()
+	events := make([]*Event, 0, count)
+	for _, e := range testEventsFor(name, count) {
+		events = append(events, NewEventAt(e.ID(), e.Name(), e.AggregateID(), e.OccurredAt(), []byte(name)))
+	}
+	if err := s.HandleFunc(NewCommand(context.Background(), AppendCmd, Append(NewStreamData(name, 0, events...)))); err != nil {
+		t.Fatalf("append to %s failed: %s", name, err)
+	}
+}
+
+func TestService_SubscribeWithID(t *testing.T) {
+	s := newTestService()
+	appendTestStream(t, s, "stream-1", 5)
+
+	first := func(id string) Entry {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		received := make(chan Entry, 1)
+		go func() {
+			_ = s.HandleFunc(NewCommand(ctx, SubscribeWithIDCmd, SubscribeWithID(id, 1, func(entries []Entry) error {
+				received <- entries[0]
+				cancel()
+				return nil
+			})))
+		}()
+		select {
+		case e := <-received:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for entry")
+		}
+		return Entry{}
+	}
+
+	if got := first("sub-1").GlobalPos; got != 1 {
+		t.Errorf("new subscription starts at %d, want 1", got)
+	}
+	if err := s.HandleFunc(NewCommand(context.Background(), AckCmd, Ack("sub-1", 3))); err != nil {
+		t.Fatalf("ack failed: %s", err)
+	}
+	if err := s.HandleFunc(NewCommand(context.Background(), AckCmd, Ack("sub-1", 2))); err != nil {
+		t.Fatalf("ack failed: %s", err)
+	}
+	if got := first("sub-1").GlobalPos; got != 4 {
+		t.Errorf("resumed subscription starts at %d, want 4", got)
+	}
+	if got := first("sub-2").GlobalPos; got != 1 {
+		t.Errorf("other subscription starts at %d, want 1", got)
+	}
+	if err := s.HandleFunc(NewCommand(context.Background(), AckCmd, Ack("", 1))); err == nil {
+		t.Error("expected error for empty subscription id")
+	}
+	if err := s.HandleFunc(NewCommand(context.Background(), AckCmd, Ack("sub-1", 6))); ErrorCode(err) != ErrInvalidRequest {
+		t.Errorf("ack beyond head: got %v", err)
+	}
+
+	subscribe := func(s *Service) error {
+		return s.HandleFunc(NewCommand(context.Background(), SubscribeWithIDCmd, SubscribeWithID("sub-1", 1,
+			func([]Entry) error {
+				t.Error("entries delivered although the acknowledged position is unknown")
+				return nil
+			})))
+	}
+	failing := newTestService(WithKeyValueStore(
+		&failingKVS{KeyValueStore: s.kvs, bucket: kvstore.KvsBucketSubscriptions, err: errors.New("timeout")}))
+	if err := subscribe(failing); ErrorCode(err) != ErrStorageFailed {
+		t.Errorf("resume subscription on failing store: got %v", err)
+	}
+	_ = s.kvs.Put(kvstore.KvsBucketSubscriptions, "sub-1", []byte{3})
+	if err := subscribe(s); ErrorCode(err) != ErrReadStreamFailed {
+		t.Errorf("resume subscription with invalid position: got %v", err)
+	}
+}
+
+func TestService_GlobalLog(t *testing.T) {
+	kvs := newTestKVS()
+	s := newTestService(WithKeyValueStore(kvs))
+	ctx := context.Background()
+	appendTestStream(t, s, "stream-1", 2)
+
+	if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData("stream-1", 0, testEventsFor("other", 1)...)))); err == nil {
+		t.Fatal("expected concurrent change error")
+	}
+	if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData(allStreamName, 0, testEventsFor("x", 1)...)))); err == nil {
+		t.Fatal("expected error for reserved stream name")
+	}
+
+	failing := &failingKVS{KeyValueStore: kvs, bucket: kvstore.KvsBucketIndex, err: errors.New("connection refused")}
+	if _, err := NewService(WithKeyValueStore(failing)); ErrorCode(err) != ErrStorageFailed {
+		t.Errorf("recover position from failing store: got %v", err)
+	}
+	restarted := newTestService(WithKeyValueStore(kvs))
+	if restarted.head() != 2 {
+		t.Fatalf("recovered position = %d, want 2", restarted.head())
+	}
+	appendTestStream(t, restarted, "stream-2", 2)
+
+	entries, err := restarted.readLog(1, restarted.head())
+	if err != nil {
+		t.Fatalf("read global log failed: %s", err)
+	}
+	want := []string{"stream-1/1", "stream-1/2", "stream-2/1", "stream-2/2"}
+	if len(entries) != len(want) {
+		t.Fatalf("global log has %d entries, want %d", len(entries), len(want))
+	}
+	for i, e := range entries {
+		if e.GlobalPos != uint64(i+1) || fmt.Sprintf("%s/%d", e.Stream, e.StreamPos) != want[i] {
+			t.Errorf("entry %d = %d:%s/%d, want %d:%s", i, e.GlobalPos, e.Stream, e.StreamPos, i+1, want[i])
+		}
+	}
+}
+
+func TestService_ReadAll(t *testing.T) {
+	s := newTestService()
+	appendTestStream(t, s, "stream-1", 3)
+	appendTestStream(t, s, "stream-2", 3)
+
+	tests := []struct {
+		name string
+		cmd  ReadAllCommand
+		want []uint64
+	}{
+		{"forward from start", ReadAll(0, 0, Forward), []uint64{1, 2, 3, 4, 5, 6}},
+		{"forward page", ReadAll(2, 3, Forward), []uint64{2, 3, 4}},
+		{"forward beyond head", ReadAll(7, 3, Forward), []uint64{}},
+		{"backward from head", ReadAll(0, 2, Backward), []uint64{6, 5}},
+		{"backward page", ReadAll(3, 5, Backward), []uint64{3, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := s.QueryEntriesFunc(NewCommand(context.Background(), ReadAllCmd, tt.cmd))
+			if err != nil {
+				t.Fatalf("readAll failed: %s", err)
+			}
+			got := make([]uint64, 0, len(entries))
+			for _, e := range entries {
+				got = append(got, e.GlobalPos)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readAll() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_Read(t *testing.T) {
+	kvs := newTestKVS()
+	s := newTestService(WithKeyValueStore(kvs))
+	ctx := context.Background()
+	appendTestStream(t, s, "stream-1", 3)
+	if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData("stream-1", 3, testEventsFor("next", 2)...)))); err != nil {
+		t.Fatalf("append failed: %s", err)
+	}
+	if _, err := kvs.Get(kvstore.KvsBucketContent, eventKey("stream-1", 5)); err != nil {
+		t.Errorf("expected event stored under its own key: %s", err)
+	}
+
+	streams, err := s.QueryFunc(NewCommand(ctx, ReadCmd, ReadRange(2, 2, Forward, "stream-1")))
+	if err != nil {
+		t.Fatalf("read failed: %s", err)
+	}
+	if streams[0].Version() != 5 || len(streams[0].Events()) != 2 || streams[0].EndOfStream() {
+		t.Errorf("read() = version %d, %d events, end of stream %v",
+			streams[0].Version(), len(streams[0].Events()), streams[0].EndOfStream())
+	}
+	if e := streams[0].Events()[2]; e == nil || e.ID() != "stream-1-c" {
+		t.Errorf("unexpected event at position 3: %v", e)
+	}
+
+	streams, err = s.QueryFunc(NewCommand(ctx, ReadAtCmd, ReadAt(ts1.Add(time.Millisecond), "stream-1")))
+	if err != nil {
+		t.Fatalf("readAt failed: %s", err)
+	}
+	if len(streams[0].Events()) != 4 {
+		t.Errorf("readAt() returned %d events, want 4", len(streams[0].Events()))
+	}
+}
+
+func TestService_MigrateLegacyStream(t *testing.T) {
+	kvs := newTestKVS()
+	events := make(map[uint64]*Event)
+	for i, e := range testEventsFor("legacy", 3) {
+		events[uint64(i)] = e
+	}
+	raw, err := buildStream("legacy", 3, events).MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = kvs.Put(kvstore.KvsBucketContent, "legacy", raw)
+	_ = kvs.Put(kvstore.KvsBucketIndex, "legacy", []byte{0, 0, 0, 0, 0, 0, 0, 3})
+
+	s := newTestService(WithKeyValueStore(kvs))
+	ctx := context.Background()
+	if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData("legacy", 3, testEventsFor("next", 1)...)))); err != nil {
+		t.Fatalf("append to legacy stream failed: %s", err)
+	}
+	streams, err := s.QueryFunc(NewCommand(ctx, ReadCmd, Read("legacy")))
+	if err != nil {
+		t.Fatalf("read failed: %s", err)
+	}
+	if len(streams[0].Events()) != 4 || streams[0].Events()[0].ID() != "legacy-a" || streams[0].Events()[3].ID() != "next-a" {
+		t.Errorf("unexpected events after migration: %v", streams[0].Events())
+	}
+	if _, err := kvs.Get(kvstore.KvsBucketContent, "legacy"); !errors.Is(err, kvstore.ErrNotFound) {
+		t.Errorf("legacy blob not removed by migration: %v", err)
+	}
+
+	// streams stay migrated once their first events are removed
+	if err := kvs.Delete(kvstore.KvsBucketContent, eventKey("legacy", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if !s.isMigrated(kvs, "legacy", 4) {
+		t.Errorf("stream without first event considered not migrated")
+	}
+}
+
+func TestService_AppendIsAtomic(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+	appendTestStream(t, s, "stream-2", 1)
+
+	err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(
+		NewStreamData("stream-1", 0, testEventsFor("stream-1", 2)...),
+		NewStreamData("stream-2", 0, testEventsFor("other", 1)...),
+	)))
+	if err == nil {
+		t.Fatal("expected concurrent change error")
+	}
+	if _, err := s.QueryFunc(NewCommand(ctx, ReadCmd, Read("stream-1"))); err == nil {
+		t.Error("expected stream-1 not to be written")
+	}
+	if s.head() != 1 {
+		t.Errorf("global position = %d, want 1", s.head())
+	}
+	if entries, _ := s.readLog(2, 3); len(entries) != 0 {
+		t.Errorf("expected no log entries after failed append, got %d", len(entries))
+	}
+}
+
+func TestService_Errors(t *testing.T) {
+	s := newTestService()
+	appendTestStream(t, s, "stream-1", 2)
+	ctx := context.Background()
+
+	err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData("stream-1", 1, testEventsFor("stream-1", 1)...))))
+	var mismatch *VersionMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("append with wrong expected version: got %v, want VersionMismatchError", err)
+	}
+	if mismatch.Stream != "stream-1" || mismatch.Expected != 1 || mismatch.Actual != 2 {
+		t.Errorf("unexpected mismatch %+v", mismatch)
+	}
+
+	_, err = s.QueryFunc(NewCommand(ctx, ReadCmd, Read("unknown")))
+	var notFound *StreamNotFoundError
+	if !errors.As(err, &notFound) || notFound.Stream != "unknown" {
+		t.Errorf("read unknown stream: got %v, want StreamNotFoundError", err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"mismatch", mismatch, ErrConcurrentChange},
+		{"not found", notFound, ErrStreamNotFound},
+		{"invalid name", s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData("", 0)))), ErrInvalidRequest},
+		{"unknown command", s.HandleFunc(NewCommand(ctx, UnknownCmd, nil)), ErrInvalidRequest},
+		{"wrapped", fmt.Errorf("wrapped: %w", mismatch), ErrConcurrentChange},
+		{"storage", storageError(errors.New("disk full")), ErrStorageFailed},
+		{"plain", errors.New("plain"), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorCode(tt.err); got != tt.want {
+				t.Errorf("ErrorCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_AppendExpectedVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    ExpectedVersion
+		version uint64
+		stream  string
+		want    uint64 // version after append, 0 if the append fails
+	}{
+		{"exact", Exact, 2, "existing", 3},
+		{"exact mismatch", Exact, 1, "existing", 0},
+		{"exact new stream", Exact, 0, "new", 1},
+		{"any existing", Any, 7, "existing", 3},
+		{"any new stream", Any, 7, "new", 1},
+		{"no stream", NoStream, 0, "new", 1},
+		{"no stream existing", NoStream, 0, "existing", 0},
+		{"stream exists", StreamExists, 0, "existing", 3},
+		{"stream exists new stream", StreamExists, 0, "new", 0},
+		{"invalid mode", StreamExists + 1, 0, "new", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService()
+			appendTestStream(t, s, "existing", 2)
+			err := s.HandleFunc(NewCommand(context.Background(), AppendCmd, Append(
+				NewStreamDataExpecting(tt.stream, tt.mode, tt.version, testEventsFor("batch", 1)...))))
+			if tt.want == 0 {
+				if err == nil {
+					t.Fatal("expected append to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("append failed: %s", err)
+			}
+			if got, _ := s.readVersion(s.kvs, tt.stream); got != tt.want {
+				t.Errorf("version = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_AppendIsIdempotent(t *testing.T) {
+	events := testEventsFor("stream-1", 3)
+	tests := []struct {
+		name     string
+		retry    StreamData
+		wantCode int
+	}{
+		{"exact", NewStreamData("stream-1", 0, events...), 0},
+		{"no stream", NewStreamDataExpecting("stream-1", NoStream, 0, events...), 0},
+		{"any", NewStreamDataExpecting("stream-1", Any, 0, events...), 0},
+		{"stream exists", NewStreamDataExpecting("stream-1", StreamExists, 0, events[1:]...), 0},
+		{"partial overlap", NewStreamData("stream-1", 2, append(events[2:], testEventsFor("other", 1)...)...), ErrEventsOverlap},
+		{"other events", NewStreamData("stream-1", 1, testEventsFor("other", 2)...), ErrConcurrentChange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService()
+			ctx := context.Background()
+			if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData("stream-1", 0, events...)))); err != nil {
+				t.Fatalf("append failed: %s", err)
+			}
+			err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(tt.retry)))
+			if got := ErrorCode(err); got != tt.wantCode {
+				t.Fatalf("retry returned %v, want code %d", err, tt.wantCode)
+			}
+			if version, _ := s.readVersion(s.kvs, "stream-1"); version != 3 || s.head() != 3 {
+				t.Errorf("version = %d, head = %d after retry, want 3", version, s.head())
+			}
+		})
+	}
+}
+
+func TestService_AppendIsIdempotentAfterScavenge(t *testing.T) {
+	events := testEventsFor("stream-1", 3)
+	tests := []struct {
+		name           string
+		truncateBefore uint64
+		wantCode       int
+	}{
+		{"retained events written", 3, 0},
+		{"no retained events", 4, ErrConcurrentChange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService()
+			ctx := context.Background()
+			for _, cmd := range []Command{
+				NewCommand(ctx, AppendCmd, Append(NewStreamData("stream-1", 0, events...))),
+				NewCommand(ctx, SetMetadataCmd, SetStreamMetadata("stream-1", NoStream, 0, StreamMetadata{TruncateBefore: tt.truncateBefore})),
+				NewCommand(ctx, ScavengeCmd, Scavenge(func(ScavengeProgress) error { return nil })),
+			} {
+				if err := s.HandleFunc(cmd); err != nil {
+					t.Fatalf("prepare stream failed: %s", err)
+				}
+			}
+			err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData("stream-1", 0, events...))))
+			if got := ErrorCode(err); got != tt.wantCode {
+				t.Fatalf("retry returned %v, want code %d", err, tt.wantCode)
+			}
+			if version, _ := s.readVersion(s.kvs, "stream-1"); version != 3 || s.head() != 3 {
+				t.Errorf("version = %d, head = %d after retry, want 3", version, s.head())
+			}
+		})
+	}
+}
+
+func TestService_DeleteStream(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+	appendTestStream(t, s, "stream-1", 3)
+	appendTestStream(t, s, "stream-2", 2)
+
+	if err := s.HandleFunc(NewCommand(ctx, DeleteCmd, DeleteStream("stream-1", Exact, 2, false))); ErrorCode(err) != ErrConcurrentChange {
+		t.Fatalf("delete with wrong expected version: got %v", err)
+	}
+	if err := s.HandleFunc(NewCommand(ctx, DeleteCmd, DeleteStream("unknown", Any, 0, false))); ErrorCode(err) != ErrStreamNotFound {
+		t.Fatalf("delete unknown stream: got %v", err)
+	}
+
+	// soft delete hides the stream until it is recreated at its old version
+	if err := s.HandleFunc(NewCommand(ctx, DeleteCmd, DeleteStream("stream-1", Exact, 3, false))); err != nil {
+		t.Fatalf("soft delete failed: %s", err)
+	}
+	if _, err := s.QueryFunc(NewCommand(ctx, ReadCmd, Read("stream-1"))); ErrorCode(err) != ErrStreamNotFound {
+		t.Fatalf("read soft deleted stream: got %v", err)
+	}
+	if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamDataExpecting("stream-1", NoStream, 0, testEventsFor("new", 2)...)))); err != nil {
+		t.Fatalf("recreate failed: %s", err)
+	}
+	streams, err := s.QueryFunc(NewCommand(ctx, ReadCmd, Read("stream-1")))
+	if err != nil {
+		t.Fatalf("read recreated stream failed: %s", err)
+	}
+	if got := streams[0]; got.Version() != 5 || len(got.Events()) != 2 || got.Events()[3].ID() != "new-a" {
+		t.Errorf("recreated stream = version %d with %d events, want version 5 with events new-a, new-b", got.Version(), len(got.Events()))
+	}
+
+	// hard delete tombstones the stream
+	if err := s.HandleFunc(NewCommand(ctx, DeleteCmd, DeleteStream("stream-2", Any, 0, true))); err != nil {
+		t.Fatalf("hard delete failed: %s", err)
+	}
+	if _, err := s.QueryFunc(NewCommand(ctx, ReadCmd, Read("stream-2"))); ErrorCode(err) != ErrStreamDeleted {
+		t.Errorf("read hard deleted stream: got %v", err)
+	}
+	if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamDataExpecting("stream-2", Any, 0, testEventsFor("new", 1)...)))); ErrorCode(err) != ErrStreamDeleted {
+		t.Errorf("append to hard deleted stream: got %v", err)
+	}
+	if _, err := s.kvs.Get(kvstore.KvsBucketContent, eventKey("stream-2", 1)); !errors.Is(err, kvstore.ErrNotFound) {
+		t.Errorf("events of hard deleted stream not removed: %v", err)
+	}
+	if entries, _ := s.readLog(1, s.head()); len(entries) != 7 {
+		t.Errorf("global log has %d entries, want 7", len(entries))
+	}
+
+	// the global log keeps the entries of deleted streams without event
+	entries, err := s.QueryEntriesFunc(NewCommand(ctx, ReadAllCmd, ReadAll(0, 0, Forward)))
+	if err != nil || len(entries) != 7 {
+		t.Fatalf("read all = %d entries, %v, want 7", len(entries), err)
+	}
+	for _, e := range entries {
+		if hidden := e.GlobalPos <= 5; hidden != (e.Event == nil) {
+			t.Errorf("read all entry %d of stream <%s> has event %v", e.GlobalPos, e.Stream, e.Event)
+		}
+	}
+
+	// subscriptions skip the events of deleted streams
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	delivered := make([]string, 0)
+	_ = s.HandleFunc(NewCommand(ctx, SubscribeWithOffsetCmd, SubscribeWithOffset(1, 0, func(entries []Entry) error {
+		for _, e := range entries {
+			delivered = append(delivered, e.Event.ID())
+		}
+		cancel()
+		return nil
+	})))
+	if want := []string{"new-a", "new-b"}; !slices.Equal(delivered, want) {
+		t.Errorf("subscription delivered %v, want %v", delivered, want)
+	}
+}
+
+func TestService_StreamMetadata(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+	appendTestStream(t, s, "stream-1", 5)
+	recent := NewEventAt("recent", "v1/test-event", "stream-1", time.Now(), nil)
+	if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData("stream-1", 5, recent)))); err != nil {
+		t.Fatalf("append failed: %s", err)
+	}
+
+	set := func(mode ExpectedVersion, expected uint64, m StreamMetadata) error {
+		return s.HandleFunc(NewCommand(ctx, SetMetadataCmd, SetStreamMetadata("stream-1", mode, expected, m)))
+	}
+	if err := set(Exact, 1, StreamMetadata{MaxCount: 4}); ErrorCode(err) != ErrConcurrentChange {
+		t.Fatalf("set metadata with wrong expected version: got %v", err)
+	}
+	if err := set(NoStream, 0, StreamMetadata{MaxCount: 4}); err != nil {
+		t.Fatalf("set metadata failed: %s", err)
+	}
+	metadata, version, err := s.QueryMetadataFunc(NewCommand(ctx, GetMetadataCmd, GetStreamMetadata("stream-1")))
+	if err != nil || version != 1 || metadata.MaxCount != 4 {
+		t.Fatalf("get metadata = %+v, version %d, %v", metadata, version, err)
+	}
+
+	tests := []struct {
+		name     string
+		metadata StreamMetadata
+		want     []uint64 // 0-based positions of the retained events
+	}{
+		{"max count", StreamMetadata{MaxCount: 4}, []uint64{2, 3, 4, 5}},
+		{"truncate before", StreamMetadata{TruncateBefore: 5}, []uint64{4, 5}},
+		{"max age", StreamMetadata{MaxAge: time.Hour}, []uint64{5}},
+		{"combined", StreamMetadata{MaxCount: 3, TruncateBefore: 5}, []uint64{4, 5}},
+		{"none", StreamMetadata{}, []uint64{0, 1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := set(Any, 0, tt.metadata); err != nil {
+				t.Fatalf("set metadata failed: %s", err)
+			}
+			streams, err := s.QueryFunc(NewCommand(ctx, ReadCmd, Read("stream-1")))
+			if err != nil {
+				t.Fatalf("read failed: %s", err)
+			}
+			got := make([]uint64, 0)
+			for pos := range streams[0].Events() {
+				got = append(got, pos)
+			}
+			slices.Sort(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("read retained %v, want %v", got, tt.want)
+			}
+
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			delivered := make([]uint64, 0)
+			_ = s.HandleFunc(NewCommand(ctx, SubscribeWithOffsetCmd, SubscribeWithOffset(1, 0, func(entries []Entry) error {
+				for _, e := range entries {
+					delivered = append(delivered, e.StreamPos-1)
+				}
+				cancel()
+				return nil
+			})))
+			if !reflect.DeepEqual(delivered, tt.want) {
+				t.Errorf("subscription delivered %v, want %v", delivered, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_Scavenge(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+	appendTestStream(t, s, "expired", 3)
+	recent := NewEventAt("recent", "v1/test-event", "expired", time.Now(), nil)
+	if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData("expired", 3, recent)))); err != nil {
+		t.Fatalf("append failed: %s", err)
+	}
+	appendTestStream(t, s, "truncated", 3)
+	appendTestStream(t, s, "soft-deleted", 2)
+	appendTestStream(t, s, "hard-deleted", 2)
+	for _, cmd := range []Command{
+		NewCommand(ctx, SetMetadataCmd, SetStreamMetadata("expired", NoStream, 0, StreamMetadata{MaxAge: time.Hour})),
+		NewCommand(ctx, SetMetadataCmd, SetStreamMetadata("truncated", NoStream, 0, StreamMetadata{MaxCount: 2})),
+		NewCommand(ctx, DeleteCmd, DeleteStream("soft-deleted", Any, 0, false)),
+		NewCommand(ctx, DeleteCmd, DeleteStream("hard-deleted", Any, 0, true)),
+	} {
+		if err := s.HandleFunc(cmd); err != nil {
+			t.Fatalf("prepare streams failed: %s", err)
+		}
+	}
+
+	var progress []ScavengeProgress
+	scavenge := func() error {
+		progress = progress[:0]
+		return s.HandleFunc(NewCommand(ctx, ScavengeCmd, Scavenge(func(p ScavengeProgress) error {
+			progress = append(progress, p)
+			return nil
+		})))
+	}
+	if err := scavenge(); err != nil {
+		t.Fatalf("scavenge failed: %s", err)
+	}
+	if got := progress[len(progress)-1]; !got.Done || got.Position != 11 || got.RemovedEvents != 8 || got.FreedBytes == 0 {
+		t.Errorf("scavenge progress = %+v, want done at position 11 with 8 removed events", got)
+	}
+	for _, key := range []string{eventKey("expired", 1), eventKey("truncated", 1), eventKey("soft-deleted", 2)} {
+		if _, err := s.kvs.Get(kvstore.KvsBucketContent, key); !errors.Is(err, kvstore.ErrNotFound) {
+			t.Errorf("event %s not removed: %v", key, err)
+		}
+	}
+	entries, err := s.readLog(1, s.head())
+	if err != nil || len(entries) != 11 {
+		t.Fatalf("global log has %d entries, want 11: %v", len(entries), err)
+	}
+	removed := 0
+	for _, e := range entries {
+		if e.Event == nil {
+			removed++
+		}
+	}
+	if removed != 8 {
+		t.Errorf("global log has %d removed events, want 8", removed)
+	}
+
+	// events removed for their age stay removed when the metadata changes
+	if err := s.HandleFunc(NewCommand(ctx, SetMetadataCmd, SetStreamMetadata("expired", Any, 0, StreamMetadata{}))); err != nil {
+		t.Fatalf("set metadata failed: %s", err)
+	}
+	streams, err := s.QueryFunc(NewCommand(ctx, ReadCmd, Read("expired", "truncated")))
+	if err != nil {
+		t.Fatalf("read failed: %s", err)
+	}
+	if len(streams[0].Events()) != 1 || streams[0].Events()[3].ID() != "recent" || len(streams[1].Events()) != 2 {
+		t.Errorf("read %d and %d events after scavenge, want 1 and 2", len(streams[0].Events()), len(streams[1].Events()))
+	}
+
+	if err := scavenge(); err != nil || progress[len(progress)-1].RemovedEvents != 0 {
+		t.Errorf("second scavenge = %+v, %v, want nothing removed", progress, err)
+	}
+}
+
+func TestService_ListStreams(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+	for _, name := range []string{"order-2", "customer-1", "order-1", "order-3", "order-4"} {
+		appendTestStream(t, s, name, 3)
+	}
+	for _, cmd := range []Command{
+		NewCommand(ctx, SetMetadataCmd, SetStreamMetadata("order-2", NoStream, 0, StreamMetadata{MaxCount: 1})),
+		NewCommand(ctx, DeleteCmd, DeleteStream("order-3", Any, 0, false)),
+		NewCommand(ctx, DeleteCmd, DeleteStream("order-4", Any, 0, true)),
+	} {
+		if err := s.HandleFunc(cmd); err != nil {
+			t.Fatalf("prepare streams failed: %s", err)
+		}
+	}
+
+	list := func(prefix, token string, pageSize uint32) ([]StreamInfo, string) {
+		streams, next, err := s.QueryStreamsFunc(NewCommand(ctx, ListStreamsCmd, ListStreams(prefix, token, pageSize)))
+		if err != nil {
+			t.Fatalf("list streams failed: %s", err)
+		}
+		return streams, next
+	}
+	streams, token := list("order-", "", 1)
+	want := []StreamInfo{{Name: "order-1", Version: 3, FirstEventAt: ts1, LastEventAt: ts1.Add(2 * time.Millisecond)}}
+	if !reflect.DeepEqual(streams, want) || token != "order-1" {
+		t.Errorf("first page = %+v, token %q, want %+v, token order-1", streams, token, want)
+	}
+	streams, token = list("order-", token, 1)
+	want = []StreamInfo{{Name: "order-2", Version: 3, FirstEventAt: ts1.Add(2 * time.Millisecond), LastEventAt: ts1.Add(2 * time.Millisecond)}}
+	if !reflect.DeepEqual(streams, want) || token != "order-2" {
+		t.Errorf("second page = %+v, token %q, want %+v, token order-2", streams, token, want)
+	}
+	if streams, token = list("order-", token, 1); len(streams) != 0 || token != "" {
+		t.Errorf("last page = %+v, token %q, want no deleted streams and no token", streams, token)
+	}
+
+	streams, token = list("", "", 0)
+	names := make([]string, 0, len(streams))
+	for _, info := range streams {
+		names = append(names, info.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"customer-1", "order-1", "order-2"}) || token != "" {
+		t.Errorf("list all = %v, token %q, want customer-1, order-1, order-2", names, token)
+	}
+}
+
+func TestService_EventMetadata(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+	headers := map[string]string{"user-id": "u-42", "content-type": "application/json"}
+	events := []*Event{
+		NewEventAt("e-1", "v1/test-event", "stream-1", ts1, []byte(`{}`),
+			WithCorrelationID("c-1"), WithCausationID("cmd-1"), WithEventMetadata(headers)),
+		NewEventAt("e-2", "v1/test-event", "stream-1", ts2, nil, WithEventMetadata(map[string]string{})),
+	}
+	if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData("stream-1", 0, events...)))); err != nil {
+		t.Fatalf("append failed: %s", err)
+	}
+	check := func(source string, got *Event, want *Event) {
+		t.Helper()
+		if got.CorrelationID() != want.CorrelationID() || got.CausationID() != want.CausationID() ||
+			!reflect.DeepEqual(got.Metadata(), want.Metadata()) {
+			t.Errorf("%s: event %s has correlation %q, causation %q, metadata %v, want %q, %q, %v", source, got.ID(),
+				got.CorrelationID(), got.CausationID(), got.Metadata(), want.CorrelationID(), want.CausationID(), want.Metadata())
+		}
+	}
+
+	streams, err := s.QueryFunc(NewCommand(ctx, ReadCmd, Read("stream-1")))
+	if err != nil {
+		t.Fatalf("read failed: %s", err)
+	}
+	entries, err := s.QueryEntriesFunc(NewCommand(ctx, ReadAllCmd, ReadAll(0, 0, Forward)))
+	if err != nil {
+		t.Fatalf("readAll failed: %s", err)
+	}
+	for i, want := range events {
+		check("read", streams[0].Events()[uint64(i)], want)
+		check("readAll", entries[i].Event, want)
+	}
+}
+
+func TestService_SchemaValidation(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+	orderPlaced := Schema{EventName: "v1/order-placed", Format: JSONSchema, Definition: []byte(`{
+		"type": "object",
+		"properties": {"orderId": {"type": "string"}, "amount": {"type": "number"}},
+		"required": ["orderId"]
+	}`)}
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+	}}
+	definition, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipped := Schema{EventName: "v1/order-shipped", Format: Protobuf, Definition: definition, MessageName: "google.protobuf.Timestamp"}
+
+	register := func(mode ExpectedVersion, expected uint64, schema Schema) error {
+		return s.HandleFunc(NewCommand(ctx, RegisterSchemaCmd, RegisterSchema(mode, expected, schema)))
+	}
+	if err := register(NoStream, 0, Schema{EventName: "v1/broken", Definition: []byte(`{"type": 42}`)}); ErrorCode(err) != ErrInvalidRequest {
+		t.Errorf("register invalid schema: got %v", err)
+	}
+	for _, ref := range []string{"file:///etc/passwd", "file:///nonexistent", "https://example.com/schema.json"} {
+		external := Schema{EventName: "v1/external", Definition: []byte(`{"$ref": "` + ref + `"}`)}
+		err := register(NoStream, 0, external)
+		if ErrorCode(err) != ErrInvalidRequest {
+			t.Errorf("register schema referencing %s: got %v", ref, err)
+		} else if msg := err.Error(); strings.Contains(msg, "invalid character") || strings.Contains(msg, "no such file") {
+			t.Errorf("register schema referencing %s leaks the filesystem: %s", ref, msg)
+		}
+	}
+	if err := register(Exact, 0, Schema{EventName: "v1/order-placed", Definition: []byte(`{}`)}); err != nil {
+		t.Fatalf("register schema failed: %s", err)
+	}
+	if err := register(Exact, 0, orderPlaced); ErrorCode(err) != ErrConcurrentChange {
+		t.Errorf("register schema with wrong expected version: got %v", err)
+	}
+	for _, schema := range []Schema{orderPlaced, shipped} {
+		if err := register(Any, 0, schema); err != nil {
+			t.Fatalf("register schema failed: %s", err)
+		}
+	}
+
+	shippedAt, _ := proto.Marshal(timestamppb.New(ts1))
+	tests := []struct {
+		name    string
+		event   string
+		payload []byte
+		want    int
+	}{
+		{"valid json", "v1/order-placed", []byte(`{"orderId": "o-1", "amount": 12.5}`), 0},
+		{"missing property", "v1/order-placed", []byte(`{"amount": 12.5}`), ErrSchemaViolation},
+		{"wrong type", "v1/order-placed", []byte(`{"orderId": 1}`), ErrSchemaViolation},
+		{"no json", "v1/order-placed", []byte(`garbage`), ErrSchemaViolation},
+		{"valid protobuf", "v1/order-shipped", shippedAt, 0},
+		{"no protobuf", "v1/order-shipped", []byte("garbage"), ErrSchemaViolation},
+		{"without schema", "v1/order-cancelled", []byte("garbage"), 0},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEventAt(fmt.Sprintf("e-%d", i), tt.event, "order-1", ts1, tt.payload)
+			err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamDataExpecting("order-1", Any, 0, e))))
+			if ErrorCode(err) != tt.want {
+				t.Errorf("append %s = %v, want error code %d", tt.payload, err, tt.want)
+			}
+		})
+	}
+
+	schemas, err := s.QuerySchemasFunc(NewCommand(ctx, ListSchemasCmd, ListSchemas("v1/order-placed")))
+	if err != nil || len(schemas) != 2 || schemas[1].Version != 2 || schemas[1].RegisteredAt.IsZero() {
+		t.Errorf("list versions = %+v, %v, want 2 versions", schemas, err)
+	}
+	schemas, err = s.QuerySchemasFunc(NewCommand(ctx, ListSchemasCmd, ListSchemas("")))
+	if err != nil || len(schemas) != 2 || schemas[0].EventName != "v1/order-placed" || schemas[0].Version != 2 {
+		t.Errorf("list latest schemas = %+v, %v, want v1/order-placed and v1/order-shipped", schemas, err)
+	}
+}
+
+func TestService_Upcast(t *testing.T) {
+	s := newTestService()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	WithUpcaster("v1/test-event", func(payload []byte) (string, []byte, error) {
+		return "v2/test-event", []byte(`{"stream":"` + string(payload) + `"}`), nil
+	})(s)
+	WithUpcaster("v2/test-event", func(payload []byte) (string, []byte, error) {
+		return "v3/test-event", append(payload, '!'), nil
+	})(s)
+	appendTestStream(t, s, "stream-1", 2)
+	check := func(source string, e *Event) {
+		t.Helper()
+		if e.Name() != "v3/test-event" || string(e.Payload()) != `{"stream":"stream-1"}!` {
+			t.Errorf("%s: event %s not upcasted: %s %s", source, e.ID(), e.Name(), e.Payload())
+		}
+	}
+
+	streams, err := s.QueryFunc(NewCommand(ctx, ReadCmd, Read("stream-1")))
+	if err != nil {
+		t.Fatalf("read failed: %s", err)
+	}
+	at, err := s.QueryFunc(NewCommand(ctx, ReadAtCmd, ReadAt(ts1.Add(time.Second), "stream-1")))
+	if err != nil {
+		t.Fatalf("readAt failed: %s", err)
+	}
+	entries, err := s.QueryEntriesFunc(NewCommand(ctx, ReadAllCmd, ReadAll(0, 0, Forward)))
+	if err != nil {
+		t.Fatalf("readAll failed: %s", err)
+	}
+	received := make(chan []Entry, 1)
+	go func() {
+		_ = s.HandleFunc(NewCommand(ctx, SubscribeWithOffsetCmd, SubscribeWithOffset(1, 0, func(entries []Entry) error {
+			received <- entries
+			return nil
+		})))
+	}()
+	var delivered []Entry
+	select {
+	case delivered = <-received:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for subscription")
+	}
+	for i := 0; i < 2; i++ {
+		check("read", streams[0].Events()[uint64(i)])
+		check("readAt", at[0].Events()[uint64(i)])
+		check("readAll", entries[i].Event)
+		check("subscribe", delivered[i].Event)
+	}
+
+	stored, err := s.readEvents(s.kvs, "stream-1", []uint64{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored[0].Name() != "v1/test-event" || string(stored[0].Payload()) != "stream-1" {
+		t.Errorf("stored event rewritten: %s %s", stored[0].Name(), stored[0].Payload())
+	}
+
+	WithUpcaster("v3/test-event", func(payload []byte) (string, []byte, error) {
+		return "", nil, errors.New("broken")
+	})(s)
+	if _, err := s.QueryFunc(NewCommand(ctx, ReadCmd, Read("stream-1"))); ErrorCode(err) != ErrReadStreamFailed {
+		t.Errorf("read with failing upcaster: got %v", err)
+	}
+	WithUpcaster("v3/test-event", func(payload []byte) (string, []byte, error) {
+		return "v1/test-event", payload, nil
+	})(s)
+	if _, err := s.QueryFunc(NewCommand(ctx, ReadCmd, Read("stream-1"))); ErrorCode(err) != ErrReadStreamFailed {
+		t.Errorf("read with cyclic upcasters: got %v", err)
+	}
+}
+
+func TestService_ShredAggregate(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+	events := []*Event{
+		NewEventAt("e-1", "v1/test-event", "customer-1", ts1, []byte(`{"name":"Jane"}`)),
+		NewEventAt("e-2", "v1/test-event", "customer-2", ts2, []byte(`{"name":"John"}`)),
+	}
+	if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData("customers", 0, events...)))); err != nil {
+		t.Fatalf("append failed: %s", err)
+	}
+	for _, bucket := range []string{kvstore.KvsBucketContent, kvstore.KvsBucketLog} {
+		kvs, _, err := s.kvs.Scan(bucket, "", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kv := range kvs {
+			if bytes.Contains(kv.Value, []byte("Jane")) || bytes.Contains(kv.Value, []byte("SmFuZ")) {
+				t.Errorf("payload stored in plain text in %s: %s", bucket, kv.Value)
+			}
+		}
+	}
+
+	if err := s.HandleFunc(NewCommand(ctx, ShredAggregateCmd, ShredAggregate(""))); ErrorCode(err) != ErrInvalidRequest {
+		t.Errorf("shred without aggregate: got %v", err)
+	}
+	if err := s.HandleFunc(NewCommand(ctx, ShredAggregateCmd, ShredAggregate("customer-1"))); err != nil {
+		t.Fatalf("shred failed: %s", err)
+	}
+	streams, err := s.QueryFunc(NewCommand(ctx, ReadCmd, Read("customers")))
+	if err != nil {
+		t.Fatalf("read failed: %s", err)
+	}
+	entries, err := s.QueryEntriesFunc(NewCommand(ctx, ReadAllCmd, ReadAll(0, 0, Forward)))
+	if err != nil {
+		t.Fatalf("readAll failed: %s", err)
+	}
+	for _, e := range []*Event{streams[0].Events()[0], entries[0].Event} {
+		if !e.Redacted() || e.Payload() != nil {
+			t.Errorf("payload of shredded event %s not redacted: %s", e.ID(), e.Payload())
+		}
+	}
+	for _, e := range []*Event{streams[0].Events()[1], entries[1].Event} {
+		if e.Redacted() || string(e.Payload()) != `{"name":"John"}` {
+			t.Errorf("unexpected payload of event %s: %s", e.ID(), e.Payload())
+		}
+	}
+
+	shredded := NewEventAt("e-3", "v1/test-event", "customer-1", ts2, []byte(`{}`))
+	if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData("customers", 2, shredded)))); ErrorCode(err) != ErrAggregateShredded {
+		t.Errorf("append to shredded aggregate: got %v", err)
+	}
+}
+
+// compactingKVS records the buckets compacted
+type compactingKVS struct {
+	kvstore.KeyValueStore
+	compacted []string
+}
+
+func (c *compactingKVS) CompactBucket(bucket string) (int64, error) {
+	c.compacted = append(c.compacted, bucket)
+	return 0, nil
+}
+
+func TestService_ShredAggregateCompactsKeys(t *testing.T) {
+	ctx := context.Background()
+	// shred returns the destroyed data key
+	shred := func(s *Service) []byte {
+		t.Helper()
+		e := NewEventAt("e-1", "v1/test-event", "customer-1", ts1, []byte(`{"name":"Jane"}`))
+		if err := s.HandleFunc(NewCommand(ctx, AppendCmd, Append(NewStreamData("customers", 0, e)))); err != nil {
+			t.Fatalf("append failed: %s", err)
+		}
+		key, err := s.kvs.Get(kvstore.KvsBucketKeys, "customer-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.HandleFunc(NewCommand(ctx, ShredAggregateCmd, ShredAggregate("customer-1"))); err != nil {
+			t.Fatalf("shred failed: %s", err)
+		}
+		return key
+	}
+
+	compacting := &compactingKVS{KeyValueStore: newTestKVS()}
+	shred(newTestService(WithKeyValueStore(compacting)))
+	if !slices.Equal(compacting.compacted, []string{kvstore.KvsBucketKeys}) {
+		t.Errorf("compacted buckets = %v, want %v", compacting.compacted, []string{kvstore.KvsBucketKeys})
+	}
+
+	// the file backend is only compacted as a whole by the scavenger
+	path := filepath.Join(t.TempDir(), "eventstore.db")
+	kvs, err := filekv.NewFileKVS(path, testBuckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = kvs.Close() })
+	s := newTestService(WithKeyValueStore(kvs))
+	key := shred(s)
+	if err := s.HandleFunc(NewCommand(ctx, ScavengeCmd, Scavenge(func(ScavengeProgress) error { return nil }))); err != nil {
+		t.Fatalf("scavenge failed: %s", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, key) {
+		t.Errorf("data key of shredded aggregate survived the scavenge in %s", path)
+	}
+}
+
+func TestService_Compression(t *testing.T) {
+	kvs := newTestKVS()
+	ctx := context.Background()
+	var s *Service
+	for i, c := range []Compression{NoCompression, Snappy, Zstd} {
+		s = newTestService(WithKeyValueStore(kvs), WithCompression(c))
+		appendTestStream(t, s, "stream-"+c.String(), 3)
+		raw, err := kvs.Get(kvstore.KvsBucketLog, logKey(uint64(3*i+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compressed := raw[0] == compressedMarker; compressed != (c != NoCompression) {
+			t.Errorf("%s: record compressed %v: %q", c, compressed, raw)
+		}
+	}
+
+	for _, c := range []Compression{NoCompression, Snappy, Zstd} {
+		streams, err := s.QueryFunc(NewCommand(ctx, ReadCmd, Read("stream-"+c.String())))
+		if err != nil {
+			t.Fatalf("read of %s records failed: %s", c, err)
+		}
+		if len(streams[0].Events()) != 3 || string(streams[0].Events()[2].Payload()) != "stream-"+c.String() {
+			t.Errorf("unexpected events of %s records: %v", c, streams[0].Events())
+		}
+	}
+	entries, err := s.QueryEntriesFunc(NewCommand(ctx, ReadAllCmd, ReadAll(0, 0, Forward)))
+	if err != nil {
+		t.Fatalf("readAll failed: %s", err)
+	}
+	if len(entries) != 9 || string(entries[8].Event.Payload()) != "stream-zstd" {
+		t.Errorf("unexpected entries: %v", entries)
+	}
+	if _, err := ParseCompression("lz4"); err == nil {
+		t.Error("unknown compression parsed")
+	}
+	for _, c := range []Compression{Snappy, Zstd} {
+		if _, err := decompress([]byte{compressedMarker, byte(c), 0xff}); ErrorCode(err) != ErrReadStreamFailed {
+			t.Errorf("decompress corrupt %s record: got %v", c, err)
+		}
+	}
+}
+
+func TestService_CompressionBeforeSealing(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"name":"Jane"}`), 100)
+	for _, c := range []Compression{NoCompression, Snappy, Zstd} {
+		s := newTestService(WithKeyValueStore(newTestKVS()), WithCompression(c))
+		sealed, err := s.seal(s.kvs, NewEventAt("e-1", "v1/test-event", "customer-1", ts1, payload))
+		if err != nil {
+			t.Fatalf("%s: seal failed: %s", c, err)
+		}
+		if compressed := len(sealed.payload) < len(payload); compressed != (c != NoCompression) {
+			t.Errorf("%s: payload of %d bytes sealed to %d bytes", c, len(payload), len(sealed.payload))
+		}
+		opened, err := s.open(s.kvs, sealed)
+		if err != nil {
+			t.Fatalf("%s: open failed: %s", c, err)
+		}
+		if !bytes.Equal(opened.payload, payload) {
+			t.Errorf("%s: unexpected payload: %s", c, opened.payload)
+		}
+	}
+}
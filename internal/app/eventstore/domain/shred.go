@@ -0,0 +1,147 @@
+package domain
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"log"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+)
+
+// dataKeySize is the size of the AES-256 data keys of the aggregates
+const dataKeySize = 32
+
+// shredAggregate destroys the data key of the aggregate. The key is replaced by an empty tombstone, so the payloads
+// of the events of the aggregate stay unreadable and no payloads are stored for the aggregate anymore. Backends
+// compacting single buckets compact the keys afterwards, others keep the destroyed key until the next scavenge.
+func (s *Service) shredAggregate(_ context.Context, cmd ShredAggregateCommand) error {
+	if cmd.aggregateID == "" {
+		return newError(ErrInvalidRequest, "aggregate id must not be empty")
+	}
+	if err := s.destroyDataKey(cmd.aggregateID); err != nil {
+		return err
+	}
+	if c, ok := s.kvs.(kvstore.BucketCompactor); ok {
+		compacted, err := c.CompactBucket(kvstore.KvsBucketKeys)
+		if err != nil {
+			return storageError(err)
+		}
+		log.Printf("[DEBUG]\t %T.shredAggregate - %d bytes of data keys compacted", s, compacted)
+	}
+	return nil
+}
+
+// destroyDataKey replaces the data key of the aggregate by an empty tombstone
+func (s *Service) destroyDataKey(aggregateID string) error {
+	s.Lock()
+	defer s.Unlock()
+	log.Printf("[INFO]\t %T.shredAggregate - destroy data key of aggregate <%s>", s, aggregateID)
+	if err := s.kvs.WithTx(func(rw kvstore.ReadWriter) error {
+		return rw.Put(kvstore.KvsBucketKeys, aggregateID, []byte{})
+	}); err != nil {
+		s.kvs.Rollback()
+		return storageError(err)
+	}
+	return nil
+}
+
+// dataKey returns the data key of the aggregate or nil if the aggregate has none. A missing key is created if create
+// is set, the caller must hold the lock of the service then. Keys of shredded aggregates fail with
+// ErrAggregateShredded.
+func (s *Service) dataKey(rw kvstore.ReadWriter, aggregateID string, create bool) ([]byte, error) {
+	key, err := rw.Get(kvstore.KvsBucketKeys, aggregateID)
+	switch {
+	case errors.Is(err, kvstore.ErrNotFound):
+		if !create {
+			return nil, nil
+		}
+	case err != nil:
+		return nil, err
+	case len(key) == 0:
+		return nil, newError(ErrAggregateShredded, "aggregate <%s> is shredded", aggregateID)
+	default:
+		return key, nil
+	}
+	key = make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, rw.Put(kvstore.KvsBucketKeys, aggregateID, key)
+}
+
+// seal returns a copy of the event with its payload encrypted by the data key of its aggregate. The payload is
+// compressed before, ciphertext doesn't compress. Events without aggregate or payload are returned as they are.
+// The caller must hold the lock of the service.
+func (s *Service) seal(rw kvstore.ReadWriter, e *Event) (*Event, error) {
+	if e == nil || e.encrypted || e.aggregateID == "" || len(e.payload) == 0 {
+		return e, nil
+	}
+	key, err := s.dataKey(rw, e.aggregateID, true)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	payload := s.compress(e.payload)
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(payload)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := *e
+	// the ID of the event is authenticated, so payloads can't be swapped between events
+	sealed.payload, sealed.encrypted = aead.Seal(nonce, nonce, payload, []byte(e.id)), true
+	sealed.compressed = s.compression != NoCompression
+	return &sealed, nil
+}
+
+// open returns a copy of the event with its payload decrypted. The payload of events of shredded aggregates is
+// redacted.
+func (s *Service) open(rw kvstore.ReadWriter, e *Event) (*Event, error) {
+	if e == nil || !e.encrypted {
+		return e, nil
+	}
+	opened := *e
+	opened.encrypted = false
+	key, err := s.dataKey(rw, e.aggregateID, false)
+	if ErrorCode(err) == ErrAggregateShredded {
+		opened.payload, opened.redacted = nil, true
+		return &opened, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, newError(ErrReadStreamFailed, "data key of aggregate <%s> missing", e.aggregateID)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.payload) < aead.NonceSize() {
+		return nil, newError(ErrReadStreamFailed, "invalid payload of event <%s>", e.id)
+	}
+	nonce, ciphertext := e.payload[:aead.NonceSize()], e.payload[aead.NonceSize():]
+	if opened.payload, err = aead.Open(nil, nonce, ciphertext, []byte(e.id)); err != nil {
+		return nil, wrapError(ErrReadStreamFailed, err, "decrypt payload of event <%s> failed", e.id)
+	}
+	if e.compressed {
+		if opened.payload, err = decompress(opened.payload); err != nil {
+			return nil, err
+		}
+	}
+	opened.compressed = false
+	return &opened, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
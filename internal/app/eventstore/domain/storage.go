@@ -0,0 +1,135 @@
+package domain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+)
+
+// eventKey returns the key of the event at the 1-based stream position. Positions are zero padded to keep keys in
+// order.
+func eventKey(stream string, pos uint64) string {
+	return fmt.Sprintf("%s/%020d", stream, pos)
+}
+
+// readVersion returns the current version of the stream
+func (s *Service) readVersion(rw kvstore.ReadWriter, stream string) (uint64, error) {
+	raw, err := rw.Get(kvstore.KvsBucketIndex, stream)
+	if errors.Is(err, kvstore.ErrNotFound) {
+		return 0, &StreamNotFoundError{Stream: stream}
+	}
+	if err != nil {
+		return 0, storageError(err)
+	}
+	if len(raw) != 8 {
+		return 0, newError(ErrReadStreamFailed, "invalid index of stream <%s>", stream)
+	}
+	return binary.BigEndian.Uint64(raw), nil
+}
+
+// writeEvents stores each event under its own key after the current version of the stream and updates the index.
+// The payloads are stored encrypted.
+func (s *Service) writeEvents(rw kvstore.ReadWriter, stream string, version uint64, events []*Event) error {
+	for i, e := range events {
+		sealed, err := s.seal(rw, e)
+		if err != nil {
+			return err
+		}
+		raw, err := sealed.MarshalJSON()
+		if err != nil {
+			log.Printf("[ERROR]\t %T.writeEvents - marshaling error: %s", s, err)
+			return err
+		}
+		if err := rw.Put(kvstore.KvsBucketContent, eventKey(stream, version+uint64(i)+1), s.compress(raw)); err != nil {
+			return err
+		}
+	}
+	idx := make([]byte, 8)
+	binary.BigEndian.PutUint64(idx, version+uint64(len(events)))
+	return rw.Put(kvstore.KvsBucketIndex, stream, idx)
+}
+
+// readEvents returns the events of the stream at the 0-based positions
+func (s *Service) readEvents(rw kvstore.ReadWriter, stream string, positions []uint64) (map[uint64]*Event, error) {
+	events := make(map[uint64]*Event, len(positions))
+	for _, pos := range positions {
+		raw, err := rw.Get(kvstore.KvsBucketContent, eventKey(stream, pos+1))
+		if err != nil {
+			return events, wrapError(ErrReadStreamFailed, err, "read event %d of stream <%s> failed", pos+1, stream)
+		}
+		if raw, err = decompress(raw); err != nil {
+			return events, err
+		}
+		var e Event
+		if err := e.UnmarshalJSON(raw); err != nil {
+			return events, err
+		}
+		opened, err := s.open(rw, &e)
+		if err != nil {
+			return events, err
+		}
+		events[pos] = opened
+	}
+	return events, nil
+}
+
+// assertMigrated converts a stream stored in the legacy layout, one JSON blob per stream in the content bucket, into
+// one key per event. It is safe to call for streams already stored in the current layout.
+func (s *Service) assertMigrated(stream string, version uint64) error {
+	if s.isMigrated(s.kvs, stream, version) {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := s.kvs.WithTx(func(rw kvstore.ReadWriter) error {
+		return s.migrate(rw, stream, version)
+	}); err != nil {
+		s.kvs.Rollback()
+		return err
+	}
+	return nil
+}
+
+// isMigrated reports whether the stream is stored in the current layout. The legacy blob is removed by the migration,
+// so the stream is migrated if the content bucket holds no blob under its name, regardless of events removed since.
+func (s *Service) isMigrated(rw kvstore.ReadWriter, stream string, version uint64) bool {
+	if version == 0 {
+		return true
+	}
+	_, err := rw.Get(kvstore.KvsBucketContent, stream)
+	return errors.Is(err, kvstore.ErrNotFound)
+}
+
+// migrate converts a stream stored in the legacy layout. The caller must hold the lock of the service.
+func (s *Service) migrate(rw kvstore.ReadWriter, stream string, version uint64) error {
+	if s.isMigrated(rw, stream, version) {
+		return nil
+	}
+	raw, err := rw.Get(kvstore.KvsBucketContent, stream)
+	if err != nil {
+		return err
+	}
+	if raw, err = decompress(raw); err != nil {
+		return err
+	}
+	var legacy Stream
+	if err := legacy.UnmarshalJSON(raw); err != nil {
+		return err
+	}
+	if uint64(len(legacy.events)) != legacy.version || legacy.version != version {
+		return newError(ErrReadStreamFailed, "version mismatch in legacy stream <%s>: index=%d, version=%d, events=%d",
+			stream, version, legacy.version, len(legacy.events))
+	}
+	events := make([]*Event, 0, len(legacy.events))
+	for pos := uint64(0); pos < legacy.version; pos++ {
+		events = append(events, legacy.events[pos])
+	}
+	log.Printf("[INFO]\t %T.migrate - migrate legacy stream <%s> with %d events", s, stream, len(events))
+	if err := s.writeEvents(rw, stream, 0, events); err != nil {
+		return err
+	}
+	return rw.Delete(kvstore.KvsBucketContent, stream)
+}
@@ -6,24 +6,65 @@ import (
 )
 
 type Stream struct {
-	name    string
-	version uint64
-	events  map[uint64]*Event
+	name        string
+	version     uint64
+	events      map[uint64]*Event
+	endOfStream bool
+	direction   Direction // order in which the events were read
 }
 
 // NewStream returns a new and empty stream in version 0
 func NewStream(name string) *Stream {
-	return &Stream{name, 0, make(map[uint64]*Event)}
+	return &Stream{name: name, events: make(map[uint64]*Event)}
 }
 
 func (s *Stream) Name() string {
 	return s.name
 }
 
+func (s *Stream) Version() uint64 {
+	return s.version
+}
+
 func (s *Stream) Events() map[uint64]*Event {
 	return s.events
 }
 
+// EndOfStream reports whether the last (or, reading backward, the first) event of the stream is included
+func (s *Stream) EndOfStream() bool {
+	return s.endOfStream
+}
+
+// Direction returns the order in which the events of the stream were read
+func (s *Stream) Direction() Direction {
+	return s.direction
+}
+
+// pageRange returns the 0-based positions of up to maxCount events of a stream in the given version starting at the
+// 1-based stream position from and whether the end of the stream is reached. Events before the 0-based position first
+// are deleted and skipped.
+func pageRange(first, version, from uint64, maxCount uint32, direction Direction) ([]uint64, bool) {
+	count := uint64(maxCount)
+	if count == 0 {
+		count = version
+	}
+	positions := make([]uint64, 0)
+	if direction == Forward {
+		start := max(from, first+1) - 1
+		for pos := start; pos < version && pos < start+count; pos++ {
+			positions = append(positions, pos)
+		}
+		return positions, start+count >= version
+	}
+	if from == 0 || from > version {
+		from = version
+	}
+	for pos := from; pos > first && pos+count > from; pos-- {
+		positions = append(positions, pos-1)
+	}
+	return positions, from <= first+count
+}
+
 // buildStream builds a stream from provided events and applies the current version and name to it
 func buildStream(name string, version uint64, events map[uint64]*Event) *Stream {
 	return &Stream{
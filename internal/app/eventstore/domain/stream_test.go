@@ -2,6 +2,7 @@ package domain
 
 import (
 	"reflect"
+	"slices"
 	"testing"
 	"time"
 )
@@ -216,3 +217,40 @@ func TestStream_UnmarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestPageRange(t *testing.T) {
+	type pageArgs struct {
+		first     uint64
+		from      uint64
+		maxCount  uint32
+		direction Direction
+	}
+	tests := []struct {
+		name            string
+		args            pageArgs
+		wantPositions   []uint64
+		wantEndOfStream bool
+	}{
+		{"all events", pageArgs{0, 0, 0, Forward}, []uint64{0, 1, 2, 3, 4}, true},
+		{"first page", pageArgs{0, 1, 2, Forward}, []uint64{0, 1}, false},
+		{"last page", pageArgs{0, 4, 2, Forward}, []uint64{3, 4}, true},
+		{"beyond end", pageArgs{0, 7, 2, Forward}, []uint64{}, true},
+		{"last events", pageArgs{0, 0, 2, Backward}, []uint64{3, 4}, false},
+		{"backward to start", pageArgs{0, 3, 5, Backward}, []uint64{0, 1, 2}, true},
+		{"skip deleted", pageArgs{2, 1, 2, Forward}, []uint64{2, 3}, false},
+		{"backward to deleted", pageArgs{2, 0, 4, Backward}, []uint64{2, 3, 4}, true},
+		{"all deleted", pageArgs{5, 0, 0, Forward}, []uint64{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, endOfStream := pageRange(tt.args.first, 5, tt.args.from, tt.args.maxCount, tt.args.direction)
+			slices.Sort(got)
+			if !reflect.DeepEqual(got, tt.wantPositions) {
+				t.Errorf("pageRange() positions = %v, want %v", got, tt.wantPositions)
+			}
+			if endOfStream != tt.wantEndOfStream {
+				t.Errorf("pageRange() endOfStream = %v, want %v", endOfStream, tt.wantEndOfStream)
+			}
+		})
+	}
+}
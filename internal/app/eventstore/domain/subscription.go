@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"sync"
+)
+
+const (
+	defaultSubscriptionLimit      = 100  // max entries delivered in one batch if the subscriber provides no limit
+	defaultSubscriptionBufferSize = 1024 // max entries buffered per subscriber before it gets dropped
+)
+
+// subscriber receives newly committed entries from the broker
+type subscriber struct {
+	entries chan Entry
+	dropped chan struct{}
+}
+
+// broker fans out committed entries to all subscribers. Slow subscribers are dropped instead of blocking writers.
+type broker struct {
+	sync.Mutex
+	bufferSize  int
+	subscribers map[*subscriber]struct{}
+}
+
+func newBroker(bufferSize int) *broker {
+	return &broker{
+		bufferSize:  bufferSize,
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+func (b *broker) subscribe() *subscriber {
+	b.Lock()
+	defer b.Unlock()
+	sub := &subscriber{
+		entries: make(chan Entry, b.bufferSize),
+		dropped: make(chan struct{}),
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (b *broker) unsubscribe(sub *subscriber) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.subscribers, sub)
+}
+
+// publish hands the entries to every subscriber without blocking. A subscriber whose buffer is full is dropped.
+func (b *broker) publish(entries []Entry) {
+	b.Lock()
+	defer b.Unlock()
+	for sub := range b.subscribers {
+		for _, e := range entries {
+			select {
+			case sub.entries <- e:
+				continue
+			default:
+			}
+			delete(b.subscribers, sub)
+			close(sub.dropped)
+			break
+		}
+	}
+}
+
+// next returns the first entry together with all immediately available entries up to limit
+func (sub *subscriber) next(first Entry, limit int) []Entry {
+	batch := []Entry{first}
+	for len(batch) < limit {
+		select {
+		case e := <-sub.entries:
+			batch = append(batch, e)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func subscriptionLimit(limit uint32) int {
+	if limit == 0 {
+		return defaultSubscriptionLimit
+	}
+	return int(limit)
+}
@@ -0,0 +1,79 @@
+package domain
+
+// Upcaster transforms the payload of an event into the next version of the event. It returns the name of the next
+// version and the transformed payload.
+type Upcaster func(payload []byte) (string, []byte, error)
+
+// WithUpcaster registers the upcaster of events with the name. Events are upcasted on reads and subscriptions until no
+// upcaster is registered for their name, the stored events stay unchanged.
+func WithUpcaster(eventName string, upcaster Upcaster) ServiceOpts {
+	return func(s *Service) {
+		s.upcasters[eventName] = upcaster
+	}
+}
+
+// upcast returns the newest version of the event, the event itself if no upcaster is registered for its name or its
+// payload is redacted. Events are shared by subscribers, so the newest version is a copy.
+func (s *Service) upcast(e *Event) (*Event, error) {
+	if e == nil || e.redacted {
+		return e, nil
+	}
+	result := e
+	for steps := 0; ; steps++ {
+		upcaster, ok := s.upcasters[result.name]
+		if !ok {
+			return result, nil
+		}
+		if steps == len(s.upcasters) {
+			return nil, newError(ErrReadStreamFailed, "upcast event <%s> failed: upcasters of <%s> form a cycle",
+				e.id, e.name)
+		}
+		name, payload, err := upcaster(result.payload)
+		if err != nil {
+			return nil, wrapError(ErrReadStreamFailed, err, "upcast event <%s> from <%s> failed", e.id, result.name)
+		}
+		next := *result
+		next.name, next.payload = name, payload
+		result = &next
+	}
+}
+
+// upcastEvents replaces the events by their newest versions
+func (s *Service) upcastEvents(events map[uint64]*Event) error {
+	for pos, e := range events {
+		upcasted, err := s.upcast(e)
+		if err != nil {
+			return err
+		}
+		events[pos] = upcasted
+	}
+	return nil
+}
+
+// upcastEntries returns the entries with the newest versions of their events
+func (s *Service) upcastEntries(entries []Entry) ([]Entry, error) {
+	if len(s.upcasters) == 0 {
+		return entries, nil
+	}
+	result := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		upcasted, err := s.upcast(e.Event)
+		if err != nil {
+			return result, err
+		}
+		e.Event = upcasted
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// upcasted wraps deliver to deliver the newest versions of the events
+func (s *Service) upcasted(deliver func([]Entry) error) func([]Entry) error {
+	return func(entries []Entry) error {
+		upcasted, err := s.upcastEntries(entries)
+		if err != nil {
+			return err
+		}
+		return deliver(upcasted)
+	}
+}
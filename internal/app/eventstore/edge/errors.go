@@ -0,0 +1,127 @@
+package edge
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/openyard/eventstore/internal/app/eventstore/domain"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+const errorDomain = "eventstore"
+
+// grpc status code and reason of the error details per error code of the domain
+var statusCodes = map[int]struct {
+	code   codes.Code
+	reason string
+}{
+	domain.ErrReadStreamFailed:    {codes.Internal, "READ_STREAM_FAILED"},
+	domain.ErrConcurrentChange:    {codes.FailedPrecondition, "WRONG_EXPECTED_VERSION"},
+	domain.ErrMisconfiguration:    {codes.FailedPrecondition, "MISCONFIGURATION"},
+	domain.ErrSubscriptionDropped: {codes.ResourceExhausted, "SUBSCRIPTION_DROPPED"},
+	domain.ErrInvalidRequest:      {codes.InvalidArgument, "INVALID_REQUEST"},
+	domain.ErrStreamNotFound:      {codes.NotFound, "STREAM_NOT_FOUND"},
+	domain.ErrStorageFailed:       {codes.Unavailable, "STORAGE_FAILED"},
+	domain.ErrEventsOverlap:       {codes.AlreadyExists, "EVENTS_OVERLAP"},
+	domain.ErrStreamDeleted:       {codes.FailedPrecondition, "STREAM_DELETED"},
+	domain.ErrScavengeRunning:     {codes.FailedPrecondition, "SCAVENGE_RUNNING"},
+	domain.ErrSchemaViolation:     {codes.InvalidArgument, "SCHEMA_VIOLATION"},
+	domain.ErrAggregateShredded:   {codes.FailedPrecondition, "AGGREGATE_SHREDDED"},
+}
+
+// toStatus translates errors of the domain to grpc status errors carrying the error code and details about the error
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	code := domain.ErrorCode(err)
+	sc, ok := statusCodes[code]
+	if !ok {
+		return status.Error(codes.Unknown, err.Error())
+	}
+	st, detailErr := status.New(sc.code, err.Error()).WithDetails(errorDetails(code, sc.reason, err)...)
+	if detailErr != nil {
+		log.Printf("[WARN]\t edge.toStatus - add details to status failed: %s", detailErr)
+		return status.Error(sc.code, err.Error())
+	}
+	return st.Err()
+}
+
+// errorDetails describes the error, errors of the domain carrying more information are described in detail
+func errorDetails(code int, reason string, err error) []protoadapt.MessageV1 {
+	var (
+		mismatch *domain.VersionMismatchError
+		notFound *domain.StreamNotFoundError
+		deleted  *domain.StreamDeletedError
+		invalid  *domain.SchemaViolationError
+	)
+	switch {
+	case errors.As(err, &mismatch):
+		return []protoadapt.MessageV1{&errdetails.ErrorInfo{
+			Reason: reason,
+			Domain: errorDomain,
+			Metadata: map[string]string{
+				"code":            strconv.Itoa(code),
+				"stream":          mismatch.Stream,
+				"expectedVersion": expectedVersion(mismatch),
+				"actualVersion":   strconv.FormatUint(mismatch.Actual, 10),
+			},
+		}}
+	case errors.As(err, &notFound):
+		return []protoadapt.MessageV1{
+			&errdetails.ErrorInfo{
+				Reason:   reason,
+				Domain:   errorDomain,
+				Metadata: map[string]string{"code": strconv.Itoa(code), "stream": notFound.Stream},
+			},
+			&errdetails.ResourceInfo{ResourceType: "stream", ResourceName: notFound.Stream},
+		}
+	case errors.As(err, &deleted):
+		return []protoadapt.MessageV1{&errdetails.ErrorInfo{
+			Reason:   reason,
+			Domain:   errorDomain,
+			Metadata: map[string]string{"code": strconv.Itoa(code), "stream": deleted.Stream},
+		}}
+	case errors.As(err, &invalid):
+		return []protoadapt.MessageV1{
+			&errdetails.ErrorInfo{
+				Reason: reason,
+				Domain: errorDomain,
+				Metadata: map[string]string{
+					"code":          strconv.Itoa(code),
+					"stream":        invalid.Stream,
+					"eventId":       invalid.EventID,
+					"eventName":     invalid.EventName,
+					"schemaVersion": strconv.FormatUint(invalid.SchemaVersion, 10),
+				},
+			},
+			&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "Payload", Description: invalid.Reason},
+			}},
+		}
+	}
+	return []protoadapt.MessageV1{&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   errorDomain,
+		Metadata: map[string]string{"code": strconv.Itoa(code)},
+	}}
+}
+
+// expectedVersion returns the expected version of an exact match or the name of the expected version otherwise
+func expectedVersion(mismatch *domain.VersionMismatchError) string {
+	if mismatch.Mode != domain.Exact {
+		return mismatch.Mode.String()
+	}
+	return strconv.FormatUint(mismatch.Expected, 10)
+}
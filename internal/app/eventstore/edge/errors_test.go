@@ -0,0 +1,53 @@
+package edge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/openyard/eventstore/internal/app/eventstore/domain"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestToStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{"nil", nil, codes.OK},
+		{"mismatch", &domain.VersionMismatchError{Stream: "s", Expected: 1, Actual: 2}, codes.FailedPrecondition},
+		{"not found", &domain.StreamNotFoundError{Stream: "s"}, codes.NotFound},
+		{"schema violation", &domain.SchemaViolationError{Stream: "s", EventName: "e"}, codes.InvalidArgument},
+		{"wrapped", fmt.Errorf("wrapped: %w", &domain.StreamNotFoundError{Stream: "s"}), codes.NotFound},
+		{"canceled", context.Canceled, codes.Canceled},
+		{"deadline", context.DeadlineExceeded, codes.DeadlineExceeded},
+		{"status", status.Error(codes.Aborted, "aborted"), codes.Aborted},
+		{"unknown", errors.New("unknown"), codes.Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(toStatus(tt.err)); got != tt.want {
+				t.Errorf("toStatus(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToStatus_VersionMismatchDetails(t *testing.T) {
+	st := status.Convert(toStatus(&domain.VersionMismatchError{Stream: "stream-1", Expected: 1, Actual: 3}))
+	if len(st.Details()) != 1 {
+		t.Fatalf("got %d details, want 1", len(st.Details()))
+	}
+	info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+	if !ok {
+		t.Fatalf("got detail %T, want *errdetails.ErrorInfo", st.Details()[0])
+	}
+	want := map[string]string{"code": "9102", "stream": "stream-1", "expectedVersion": "1", "actualVersion": "3"}
+	if info.Reason != "WRONG_EXPECTED_VERSION" || fmt.Sprint(info.Metadata) != fmt.Sprint(want) {
+		t.Errorf("unexpected details %s: %v", info.Reason, info.Metadata)
+	}
+}
@@ -11,8 +11,10 @@ import (
 )
 
 var (
-	_ grpcapi.EventStoreServer = (*GrpcTransport)(nil)
-	_ grpcapi.TransportServer  = (*GrpcTransport)(nil)
+	_ grpcapi.EventStoreServer     = (*GrpcTransport)(nil)
+	_ grpcapi.TransportServer      = (*GrpcTransport)(nil)
+	_ grpcapi.AdminServer          = (*GrpcTransport)(nil)
+	_ grpcapi.SchemaRegistryServer = (*GrpcTransport)(nil)
 )
 
 type GrpcTransportOption func(transport *GrpcTransport)
@@ -20,23 +22,42 @@ type GrpcTransportOption func(transport *GrpcTransport)
 type GrpcTransport struct {
 	grpcapi.UnimplementedEventStoreServer
 	grpcapi.UnimplementedTransportServer
+	grpcapi.UnimplementedAdminServer
+	grpcapi.UnimplementedSchemaRegistryServer
+	nodeID int64
 	node   *snowflake.Node
 	handle func(cmd domain.Command) error
 	query  func(cmd domain.Command) ([]domain.Stream, error)
+	// queryEntries answers queries across streams in the order of the global log
+	queryEntries func(cmd domain.Command) ([]domain.Entry, error)
+	// queryMetadata answers queries for the metadata of a stream and its version
+	queryMetadata func(cmd domain.Command) (domain.StreamMetadata, uint64, error)
+	// queryStreams answers queries listing streams with a page of streams and the token continuing the listing
+	queryStreams func(cmd domain.Command) ([]domain.StreamInfo, string, error)
+	// querySchemas answers queries for the schemas validating the payloads of events
+	querySchemas func(cmd domain.Command) ([]domain.Schema, error)
 }
 
 func NewGrpcTransport(opts ...GrpcTransportOption) *GrpcTransport {
-	node, err := snowflake.NewNode(1)
-	assertNoError(err)
 	t := &GrpcTransport{
-		node: node,
+		nodeID: 1,
 	}
 	for _, opt := range opts {
 		opt(t)
 	}
+	node, err := snowflake.NewNode(t.nodeID)
+	assertNoError(err)
+	t.node = node
 	return t
 }
 
+// WithNodeID sets the snowflake node id of the transport, which must be unique per server
+func WithNodeID(nodeID int64) GrpcTransportOption {
+	return func(g *GrpcTransport) {
+		g.nodeID = nodeID
+	}
+}
+
 func WithHandleFunc(hf func(cmd domain.Command) error) GrpcTransportOption {
 	return func(g *GrpcTransport) {
 		g.handle = hf
@@ -49,22 +70,78 @@ func WithQueryFunc(qf func(cmd domain.Command) ([]domain.Stream, error)) GrpcTra
 	}
 }
 
+func WithQueryEntriesFunc(qf func(cmd domain.Command) ([]domain.Entry, error)) GrpcTransportOption {
+	return func(g *GrpcTransport) {
+		g.queryEntries = qf
+	}
+}
+
+func WithQueryMetadataFunc(qf func(cmd domain.Command) (domain.StreamMetadata, uint64, error)) GrpcTransportOption {
+	return func(g *GrpcTransport) {
+		g.queryMetadata = qf
+	}
+}
+
+func WithQueryStreamsFunc(qf func(cmd domain.Command) ([]domain.StreamInfo, string, error)) GrpcTransportOption {
+	return func(g *GrpcTransport) {
+		g.queryStreams = qf
+	}
+}
+
+func WithQuerySchemasFunc(qf func(cmd domain.Command) ([]domain.Schema, error)) GrpcTransportOption {
+	return func(g *GrpcTransport) {
+		g.querySchemas = qf
+	}
+}
+
 func (g GrpcTransport) Append(ctx context.Context, request *grpcapi.AppendRequest) (*grpcapi.Empty, error) {
 	start := time.Now()
 	defer func() { log.Printf("[ACCESS]\t %T.Append took %s", g, time.Since(start)) }()
 	var streamData []domain.StreamData
 	for _, s := range request.StreamData {
-		streamData = append(streamData, domain.NewStreamData(s.Name, s.ExpectedVersion, api2domain(s.Events)...))
-
+		streamData = append(streamData, domain.NewStreamDataExpecting(s.Name,
+			api2domainExpectedVersion(s.ExpectedVersionMode), s.ExpectedVersion, api2domain(s.Events)...))
 	}
 	cmd := domain.Append(streamData...)
-	return &grpcapi.Empty{}, g.handle(domain.NewCommand(ctx, domain.AppendCmd, cmd))
+	return &grpcapi.Empty{}, toStatus(g.handle(domain.NewCommand(ctx, domain.AppendCmd, cmd)))
+}
+
+func (g GrpcTransport) DeleteStream(ctx context.Context, request *grpcapi.DeleteStreamRequest) (*grpcapi.Empty, error) {
+	start := time.Now()
+	defer func() { log.Printf("[ACCESS]\t %T.DeleteStream took %s", g, time.Since(start)) }()
+	cmd := domain.DeleteStream(request.Name, api2domainExpectedVersion(request.ExpectedVersionMode),
+		request.ExpectedVersion, request.Hard)
+	return &grpcapi.Empty{}, toStatus(g.handle(domain.NewCommand(ctx, domain.DeleteCmd, cmd)))
+}
+
+func (g GrpcTransport) GetStreamMetadata(ctx context.Context, request *grpcapi.GetStreamMetadataRequest) (*grpcapi.StreamMetadata, error) {
+	start := time.Now()
+	defer func() { log.Printf("[ACCESS]\t %T.GetStreamMetadata took %s", g, time.Since(start)) }()
+	cmd := domain.GetStreamMetadata(request.Name)
+	metadata, version, err := g.queryMetadata(domain.NewCommand(ctx, domain.GetMetadataCmd, cmd))
+	return domainMetadata2api(request.Name, version, metadata), toStatus(err)
+}
+
+func (g GrpcTransport) SetStreamMetadata(ctx context.Context, request *grpcapi.SetStreamMetadataRequest) (*grpcapi.Empty, error) {
+	start := time.Now()
+	defer func() { log.Printf("[ACCESS]\t %T.SetStreamMetadata took %s", g, time.Since(start)) }()
+	cmd := domain.SetStreamMetadata(request.Metadata.GetName(), api2domainExpectedVersion(request.ExpectedVersionMode),
+		request.ExpectedVersion, api2domainMetadata(request.Metadata))
+	return &grpcapi.Empty{}, toStatus(g.handle(domain.NewCommand(ctx, domain.SetMetadataCmd, cmd)))
+}
+
+func (g GrpcTransport) ListStreams(ctx context.Context, request *grpcapi.ListStreamsRequest) (*grpcapi.ListStreamsResponse, error) {
+	start := time.Now()
+	defer func() { log.Printf("[ACCESS]\t %T.ListStreams took %s", g, time.Since(start)) }()
+	cmd := domain.ListStreams(request.Prefix, request.ContinuationToken, request.PageSize)
+	streams, token, err := g.queryStreams(domain.NewCommand(ctx, domain.ListStreamsCmd, cmd))
+	return domainStreamInfos2api(streams, token), toStatus(err)
 }
 
 func (g GrpcTransport) Read(ctx context.Context, request *grpcapi.ReadRequest) (*grpcapi.Streams, error) {
 	start := time.Now()
 	defer func() { log.Printf("[ACCESS]\t %T.Read took %s", g, time.Since(start)) }()
-	cmd := domain.Read(request.Streams...)
+	cmd := domain.ReadRange(request.From, request.MaxCount, api2domainDirection(request.Direction), request.Streams...)
 	streams, err := g.query(domain.NewCommand(ctx, domain.ReadCmd, cmd))
 	return domainStream2ApiStream(streams, err)
 }
@@ -77,27 +154,86 @@ func (g GrpcTransport) ReadAt(ctx context.Context, request *grpcapi.ReadAtReques
 	return domainStream2ApiStream(streams, err)
 }
 
+func (g GrpcTransport) ReadAll(ctx context.Context, request *grpcapi.ReadAllRequest) (*grpcapi.Entries, error) {
+	start := time.Now()
+	defer func() { log.Printf("[ACCESS]\t %T.ReadAll took %s", g, time.Since(start)) }()
+	cmd := domain.ReadAll(request.Position, request.MaxCount, api2domainDirection(request.Direction))
+	entries, err := g.queryEntries(domain.NewCommand(ctx, domain.ReadAllCmd, cmd))
+	return domainEntries2api(entries), toStatus(err)
+}
+
 func (g GrpcTransport) Subscribe(request *grpcapi.SubscriptionRequest, server grpcapi.Transport_SubscribeServer) error {
-	//TODO implement me
-	panic("implement me")
+	start := time.Now()
+	defer func() { log.Printf("[ACCESS]\t %T.Subscribe took %s", g, time.Since(start)) }()
+	cmd := domain.Subscribe(request.Limit, func(entries []domain.Entry) error {
+		return server.Send(domainEntries2api(entries))
+	})
+	return toStatus(g.handle(domain.NewCommand(server.Context(), domain.SubscribeCmd, cmd)))
 }
 
 func (g GrpcTransport) SubscribeWithID(request *grpcapi.SubscriptionWithIDRequest, server grpcapi.Transport_SubscribeWithIDServer) error {
-	//TODO implement me
-	panic("implement me")
+	start := time.Now()
+	defer func() { log.Printf("[ACCESS]\t %T.SubscribeWithID took %s", g, time.Since(start)) }()
+	cmd := domain.SubscribeWithID(request.SubscriptionID, request.Limit, func(entries []domain.Entry) error {
+		return server.Send(domainEntries2api(entries))
+	})
+	return toStatus(g.handle(domain.NewCommand(server.Context(), domain.SubscribeWithIDCmd, cmd)))
 }
 
 func (g GrpcTransport) SubscribeWithOffset(request *grpcapi.SubscriptionWithOffsetRequest, server grpcapi.Transport_SubscribeWithOffsetServer) error {
-	//TODO implement me
-	panic("implement me")
+	start := time.Now()
+	defer func() { log.Printf("[ACCESS]\t %T.SubscribeWithOffset took %s", g, time.Since(start)) }()
+	cmd := domain.SubscribeWithOffset(request.Offset, request.Limit, func(entries []domain.Entry) error {
+		return server.Send(domainEntries2api(entries))
+	})
+	return toStatus(g.handle(domain.NewCommand(server.Context(), domain.SubscribeWithOffsetCmd, cmd)))
+}
+
+func (g GrpcTransport) Ack(ctx context.Context, request *grpcapi.AckRequest) (*grpcapi.Empty, error) {
+	start := time.Now()
+	defer func() { log.Printf("[ACCESS]\t %T.Ack took %s", g, time.Since(start)) }()
+	cmd := domain.Ack(request.SubscriptionID, request.Position)
+	return &grpcapi.Empty{}, toStatus(g.handle(domain.NewCommand(ctx, domain.AckCmd, cmd)))
+}
+
+func (g GrpcTransport) RegisterSchema(ctx context.Context, request *grpcapi.RegisterSchemaRequest) (*grpcapi.Empty, error) {
+	start := time.Now()
+	defer func() { log.Printf("[ACCESS]\t %T.RegisterSchema took %s", g, time.Since(start)) }()
+	cmd := domain.RegisterSchema(api2domainExpectedVersion(request.ExpectedVersionMode), request.ExpectedVersion,
+		api2domainSchema(request.Schema))
+	return &grpcapi.Empty{}, toStatus(g.handle(domain.NewCommand(ctx, domain.RegisterSchemaCmd, cmd)))
+}
+
+func (g GrpcTransport) ListSchemas(ctx context.Context, request *grpcapi.ListSchemasRequest) (*grpcapi.Schemas, error) {
+	start := time.Now()
+	defer func() { log.Printf("[ACCESS]\t %T.ListSchemas took %s", g, time.Since(start)) }()
+	cmd := domain.ListSchemas(request.EventName)
+	schemas, err := g.querySchemas(domain.NewCommand(ctx, domain.ListSchemasCmd, cmd))
+	return domainSchemas2api(schemas), toStatus(err)
+}
+
+func (g GrpcTransport) Scavenge(_ *grpcapi.ScavengeRequest, server grpcapi.Admin_ScavengeServer) error {
+	start := time.Now()
+	defer func() { log.Printf("[ACCESS]\t %T.Scavenge took %s", g, time.Since(start)) }()
+	cmd := domain.Scavenge(func(progress domain.ScavengeProgress) error {
+		return server.Send(domainScavengeProgress2api(progress))
+	})
+	return toStatus(g.handle(domain.NewCommand(server.Context(), domain.ScavengeCmd, cmd)))
+}
+
+func (g GrpcTransport) ShredAggregate(ctx context.Context, request *grpcapi.ShredAggregateRequest) (*grpcapi.Empty, error) {
+	start := time.Now()
+	defer func() { log.Printf("[ACCESS]\t %T.ShredAggregate took %s", g, time.Since(start)) }()
+	cmd := domain.ShredAggregate(request.AggregateID)
+	return &grpcapi.Empty{}, toStatus(g.handle(domain.NewCommand(ctx, domain.ShredAggregateCmd, cmd)))
 }
 
 func domainStream2ApiStream(streams []domain.Stream, err error) (*grpcapi.Streams, error) {
 	result := &grpcapi.Streams{Streams: make([]*grpcapi.Stream, 0)}
 	for _, stream := range streams {
-		result.Streams = append(result.Streams, domain2api(stream.Name(), stream.Events()))
+		result.Streams = append(result.Streams, domain2api(stream))
 	}
-	return result, err
+	return result, toStatus(err)
 }
 
 func assertNoError(err error) {
@@ -1,9 +1,13 @@
 package edge
 
 import (
+	"cmp"
+	"slices"
+
 	"github.com/openyard/eventstore/internal/app/eventstore/domain"
 	"github.com/openyard/eventstore/pkg/genproto/grpcapi"
 
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -16,22 +20,147 @@ func api2domain(events []*grpcapi.Event) []*domain.Event {
 			e.AggregateID,
 			e.OccurredAt.AsTime(),
 			e.Payload,
+			domain.WithCorrelationID(e.CorrelationID),
+			domain.WithCausationID(e.CausationID),
+			domain.WithEventMetadata(e.Metadata),
 		))
 	}
 	return res
 }
 
-func domain2api(streamName string, events map[uint64]*domain.Event) *grpcapi.Stream {
-	res := &grpcapi.Stream{Name: streamName, Version: uint64(len(events)), Events: make([]*grpcapi.Event, 0, len(events))}
+func api2domainDirection(direction grpcapi.Direction) domain.Direction {
+	if direction == grpcapi.Direction_Backward {
+		return domain.Backward
+	}
+	return domain.Forward
+}
+
+// api2domainExpectedVersion translates the mode, the values of both enums match so unknown modes are rejected by the domain
+func api2domainExpectedVersion(mode grpcapi.ExpectedVersionMode) domain.ExpectedVersion {
+	return domain.ExpectedVersion(mode)
+}
+
+func api2domainMetadata(m *grpcapi.StreamMetadata) domain.StreamMetadata {
+	return domain.StreamMetadata{
+		MaxCount:       m.GetMaxCount(),
+		MaxAge:         m.GetMaxAge().AsDuration(),
+		TruncateBefore: m.GetTruncateBefore(),
+	}
+}
+
+func domainMetadata2api(name string, version uint64, m domain.StreamMetadata) *grpcapi.StreamMetadata {
+	res := &grpcapi.StreamMetadata{
+		Name:           name,
+		Version:        version,
+		MaxCount:       m.MaxCount,
+		TruncateBefore: m.TruncateBefore,
+	}
+	if m.MaxAge > 0 {
+		res.MaxAge = durationpb.New(m.MaxAge)
+	}
+	return res
+}
+
+func domain2api(stream domain.Stream) *grpcapi.Stream {
+	events := stream.Events()
+	res := &grpcapi.Stream{
+		Name:        stream.Name(),
+		Version:     stream.Version(),
+		Events:      make([]*grpcapi.Event, 0, len(events)),
+		EndOfStream: stream.EndOfStream(),
+	}
 	for p, e := range events {
-		res.Events = append(res.Events, &grpcapi.Event{
-			ID:          e.ID(),
-			Name:        e.Name(),
-			AggregateID: e.AggregateID(),
-			Pos:         p + 1,
-			Payload:     e.Payload(),
-			OccurredAt:  timestamppb.New(e.OccurredAt()),
+		res.Events = append(res.Events, domainEvent2api(p+1, e))
+	}
+	slices.SortFunc(res.Events, func(a, b *grpcapi.Event) int {
+		if stream.Direction() == domain.Backward {
+			return cmp.Compare(b.Pos, a.Pos)
+		}
+		return cmp.Compare(a.Pos, b.Pos)
+	})
+	return res
+}
+
+func domainEntries2api(entries []domain.Entry) *grpcapi.Entries {
+	res := &grpcapi.Entries{Entries: make([]*grpcapi.Entry, 0, len(entries))}
+	for _, e := range entries {
+		res.Entries = append(res.Entries, &grpcapi.Entry{
+			GlobalPos:  e.GlobalPos,
+			StreamName: e.Stream,
+			StreamPos:  e.StreamPos,
+			Event:      domainEvent2api(e.StreamPos, e.Event),
 		})
 	}
 	return res
 }
+
+// domainEvent2api translates the event, events removed by the scavenger are nil
+func domainEvent2api(pos uint64, e *domain.Event) *grpcapi.Event {
+	if e == nil {
+		return nil
+	}
+	return &grpcapi.Event{
+		ID:            e.ID(),
+		Name:          e.Name(),
+		AggregateID:   e.AggregateID(),
+		Pos:           pos,
+		Payload:       e.Payload(),
+		OccurredAt:    timestamppb.New(e.OccurredAt()),
+		CorrelationID: e.CorrelationID(),
+		CausationID:   e.CausationID(),
+		Metadata:      e.Metadata(),
+		Redacted:      e.Redacted(),
+	}
+}
+
+func domainStreamInfos2api(streams []domain.StreamInfo, token string) *grpcapi.ListStreamsResponse {
+	res := &grpcapi.ListStreamsResponse{Streams: make([]*grpcapi.StreamInfo, 0, len(streams)), ContinuationToken: token}
+	for _, s := range streams {
+		info := &grpcapi.StreamInfo{Name: s.Name, Version: s.Version}
+		if !s.FirstEventAt.IsZero() {
+			info.FirstEventAt = timestamppb.New(s.FirstEventAt)
+		}
+		if !s.LastEventAt.IsZero() {
+			info.LastEventAt = timestamppb.New(s.LastEventAt)
+		}
+		res.Streams = append(res.Streams, info)
+	}
+	return res
+}
+
+// api2domainSchema translates the schema, the values of both format enums match so unknown formats are rejected by
+// the domain
+func api2domainSchema(s *grpcapi.Schema) domain.Schema {
+	return domain.Schema{
+		EventName:   s.GetEventName(),
+		Format:      domain.SchemaFormat(s.GetFormat()),
+		Definition:  s.GetDefinition(),
+		MessageName: s.GetMessageName(),
+	}
+}
+
+func domainSchemas2api(schemas []domain.Schema) *grpcapi.Schemas {
+	res := &grpcapi.Schemas{Schemas: make([]*grpcapi.Schema, 0, len(schemas))}
+	for _, s := range schemas {
+		res.Schemas = append(res.Schemas, &grpcapi.Schema{
+			EventName:    s.EventName,
+			Version:      s.Version,
+			Format:       grpcapi.SchemaFormat(s.Format),
+			Definition:   s.Definition,
+			MessageName:  s.MessageName,
+			RegisteredAt: timestamppb.New(s.RegisteredAt),
+		})
+	}
+	return res
+}
+
+func domainScavengeProgress2api(p domain.ScavengeProgress) *grpcapi.ScavengeProgress {
+	return &grpcapi.ScavengeProgress{
+		Position:       p.Position,
+		Head:           p.Head,
+		RemovedEvents:  p.RemovedEvents,
+		FreedBytes:     p.FreedBytes,
+		CompactedBytes: p.CompactedBytes,
+		Done:           p.Done,
+	}
+}
@@ -0,0 +1,50 @@
+package edge
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/openyard/eventstore/internal/app/eventstore/domain"
+	"github.com/openyard/eventstore/internal/app/kvstore"
+	"github.com/openyard/eventstore/pkg/kvstore/memkv"
+)
+
+func TestDomain2api_KeepsDirection(t *testing.T) {
+	s, err := domain.NewService(domain.WithKeyValueStore(memkv.NewMemoryKVS(kvstore.KvsBucketIndex, kvstore.KvsBucketContent,
+		kvstore.KvsBucketLog, kvstore.KvsBucketDeleted, kvstore.KvsBucketMeta, kvstore.KvsBucketSchemas, kvstore.KvsBucketKeys)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	at := time.Date(2024, 9, 11, 21, 51, 39, 0, time.UTC)
+	events := []*domain.Event{
+		domain.NewEventAt("e-1", "v1/test-event", "a-1", at, nil),
+		domain.NewEventAt("e-2", "v1/test-event", "a-1", at.Add(time.Second), nil),
+		domain.NewEventAt("e-3", "v1/test-event", "a-1", at.Add(2*time.Second), nil),
+	}
+	if err := s.HandleFunc(domain.NewCommand(ctx, domain.AppendCmd, domain.Append(domain.NewStreamData("stream-1", 0, events...)))); err != nil {
+		t.Fatalf("append failed: %s", err)
+	}
+	tests := []struct {
+		direction domain.Direction
+		want      []uint64
+	}{
+		{domain.Forward, []uint64{1, 2, 3}},
+		{domain.Backward, []uint64{3, 2, 1}},
+	}
+	for _, tt := range tests {
+		streams, err := s.QueryFunc(domain.NewCommand(ctx, domain.ReadCmd, domain.ReadRange(0, 0, tt.direction, "stream-1")))
+		if err != nil {
+			t.Fatalf("read failed: %s", err)
+		}
+		got := make([]uint64, 0)
+		for _, e := range domain2api(streams[0]).Events {
+			got = append(got, e.Pos)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("direction %d: got positions %v, want %v", tt.direction, got, tt.want)
+		}
+	}
+}
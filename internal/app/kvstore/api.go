@@ -1,15 +1,58 @@
 package kvstore
 
+import "errors"
+
 const (
-	KvsBucketIndex   = "_index"
-	KvsBucketContent = "_content"
+	KvsBucketIndex         = "_index"
+	KvsBucketContent       = "_content"
+	KvsBucketLog           = "_log"
+	KvsBucketSubscriptions = "_subscriptions"
+	KvsBucketDeleted       = "_deleted"
+	KvsBucketMeta          = "_meta"
+	KvsBucketSchemas       = "_schemas"
+	KvsBucketKeys          = "_keys"
 )
 
 // KeyValueStore provides an interface for a key-value-store
 type KeyValueStore interface {
+	ReadWriter
 	AssertBucket(bucket string) error
+	// WithTx runs all functions within one transaction, which is passed to them. Writes of the transaction are only
+	// visible to the transaction until it is committed, operations on the store itself never join the transaction.
+	WithTx(fn ...func(tx ReadWriter) error) error
+	Rollback()
+}
+
+// ReadWriter reads and writes the keys of buckets, it is implemented by key-value-stores and their transactions
+type ReadWriter interface {
 	Put(bucket, key string, value []byte) error
 	Get(bucket, key string) ([]byte, error)
-	WithTx(fn ...func() error) error
-	Rollback()
+	// Delete removes the key from the bucket, deleting a missing key is no error
+	Delete(bucket, key string) error
+	// Scan returns up to limit keys of the bucket starting with prefix in ascending byte order, starting after the
+	// cursor. An empty cursor starts at the first key, a limit of 0 returns all keys. The returned cursor continues the
+	// scan and is empty once all keys were returned.
+	Scan(bucket, prefix, cursor string, limit int) ([]KeyValue, string, error)
+}
+
+// KeyValue is a key of a bucket and its value
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// Compactor is implemented by key-value-stores which reclaim the storage of deleted or overwritten values on demand
+type Compactor interface {
+	// Compact reclaims storage and returns the count of bytes freed
+	Compact() (int64, error)
 }
+
+// BucketCompactor is implemented by key-value-stores which reclaim the storage of a single bucket at a cost
+// proportional to the bucket
+type BucketCompactor interface {
+	// CompactBucket reclaims the storage of the bucket and returns the count of bytes freed
+	CompactBucket(bucket string) (int64, error)
+}
+
+// ErrNotFound is wrapped by the errors of key-value-stores returned for missing keys
+var ErrNotFound = errors.New("not found")
@@ -0,0 +1,65 @@
+package kvstore
+
+import (
+	"slices"
+	"strings"
+)
+
+// SortedKeys keeps the keys of a bucket in ascending order, so scans seek their first key instead of sorting all keys
+type SortedKeys []string
+
+// Insert returns the keys including the key
+func (k SortedKeys) Insert(key string) SortedKeys {
+	i, found := slices.BinarySearch(k, key)
+	if found {
+		return k
+	}
+	return slices.Insert(k, i, key)
+}
+
+// Remove returns the keys without the key
+func (k SortedKeys) Remove(key string) SortedKeys {
+	i, found := slices.BinarySearch(k, key)
+	if !found {
+		return k
+	}
+	return slices.Delete(k, i, i+1)
+}
+
+// Scan returns the keys as KeyValueStore.Scan does, overlaid by the keys written within a transaction: keys mapped to
+// true are added and keys mapped to false are deleted
+func (k SortedKeys) Scan(prefix, cursor string, limit int, overlay map[string]bool) ([]string, string) {
+	added := make([]string, 0)
+	for key, exists := range overlay {
+		if exists && strings.HasPrefix(key, prefix) && key > cursor {
+			added = append(added, key)
+		}
+	}
+	slices.Sort(added)
+	keys := make([]string, 0)
+	i, _ := slices.BinarySearch(k, max(prefix, cursor))
+	for (i < len(k) || len(added) > 0) && (limit == 0 || len(keys) <= limit) {
+		var key string
+		if i < len(k) && (len(added) == 0 || k[i] < added[0]) {
+			key, i = k[i], i+1
+		} else {
+			key, added = added[0], added[1:]
+			if i < len(k) && k[i] == key {
+				i++
+			}
+		}
+		if !strings.HasPrefix(key, prefix) {
+			// the keys with the prefix are contiguous, so no further key has it
+			break
+		}
+		if exists, ok := overlay[key]; key == cursor || ok && !exists {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	next := ""
+	if limit > 0 && len(keys) > limit {
+		keys, next = keys[:limit], keys[limit-1]
+	}
+	return keys, next
+}
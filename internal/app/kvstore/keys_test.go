@@ -0,0 +1,50 @@
+package kvstore_test
+
+import (
+	"testing"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortedKeys(t *testing.T) {
+	var sut kvstore.SortedKeys
+	for _, key := range []string{"b/2", "a/1", "c/1", "b/1", "a/1"} {
+		sut = sut.Insert(key)
+	}
+	assert.Equal(t, kvstore.SortedKeys{"a/1", "b/1", "b/2", "c/1"}, sut)
+	sut = sut.Remove("c/1").Remove("d/1")
+	assert.Equal(t, kvstore.SortedKeys{"a/1", "b/1", "b/2"}, sut)
+}
+
+func TestSortedKeys_Scan(t *testing.T) {
+	sut := kvstore.SortedKeys{"a/1", "b/1", "b/2", "b/4", "c/1"}
+	tests := []struct {
+		name     string
+		prefix   string
+		cursor   string
+		limit    int
+		overlay  map[string]bool
+		wantKeys []string
+		wantNext string
+	}{
+		{"all", "", "", 0, nil, []string{"a/1", "b/1", "b/2", "b/4", "c/1"}, ""},
+		{"prefix", "b/", "", 0, nil, []string{"b/1", "b/2", "b/4"}, ""},
+		{"cursor", "b/", "b/1", 0, nil, []string{"b/2", "b/4"}, ""},
+		{"cursor before prefix", "b/", "a/9", 0, nil, []string{"b/1", "b/2", "b/4"}, ""},
+		{"limit", "", "", 2, nil, []string{"a/1", "b/1"}, "b/1"},
+		{"limit of all keys", "b/", "", 3, nil, []string{"b/1", "b/2", "b/4"}, ""},
+		{"unknown prefix", "x/", "", 0, nil, []string{}, ""},
+		{"overlay", "b/", "", 0, map[string]bool{"b/1": false, "b/3": true, "b/4": true, "c/2": true},
+			[]string{"b/2", "b/3", "b/4"}, ""},
+		{"overlay with limit", "", "a/1", 3, map[string]bool{"a/2": true, "b/1": false, "b/2": true},
+			[]string{"a/2", "b/2", "b/4"}, "b/4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, next := sut.Scan(tt.prefix, tt.cursor, tt.limit, tt.overlay)
+			assert.Equal(t, tt.wantKeys, keys)
+			assert.Equal(t, tt.wantNext, next)
+		})
+	}
+}
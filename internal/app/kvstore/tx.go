@@ -0,0 +1,74 @@
+package kvstore
+
+import "fmt"
+
+// Write is the write of a value or the deletion of a key
+type Write struct {
+	Value   []byte
+	Deleted bool
+}
+
+// Writes are the writes of a transaction per bucket and key
+type Writes map[string]map[string]Write
+
+// TxBuffer buffers the writes of a transaction, its reads see its writes on top of the committed values of the store.
+// Stores commit the pending writes after all functions of the transaction succeeded.
+type TxBuffer struct {
+	Pending      Writes
+	assertBucket func(bucket string) error
+	get          func(bucket, key string) ([]byte, error)
+	scan         func(bucket, prefix, cursor string, limit int, pending map[string]Write) ([]KeyValue, string, error)
+}
+
+// NewTxBuffer returns an empty buffer reading the committed values of the store by the functions, which must be safe
+// to call concurrently with writes. scan overlays the committed keys of the bucket by the pending writes.
+func NewTxBuffer(
+	assertBucket func(bucket string) error,
+	get func(bucket, key string) ([]byte, error),
+	scan func(bucket, prefix, cursor string, limit int, pending map[string]Write) ([]KeyValue, string, error),
+) *TxBuffer {
+	return &TxBuffer{Pending: make(Writes), assertBucket: assertBucket, get: get, scan: scan}
+}
+
+func (tx *TxBuffer) Put(bucket, key string, value []byte) error {
+	return tx.buffer(bucket, key, Write{Value: value})
+}
+
+func (tx *TxBuffer) Delete(bucket, key string) error {
+	return tx.buffer(bucket, key, Write{Deleted: true})
+}
+
+func (tx *TxBuffer) Get(bucket, key string) ([]byte, error) {
+	if w, ok := tx.Pending[bucket][key]; ok {
+		if w.Deleted {
+			return nil, fmt.Errorf("key (%s:%s) %w", bucket, key, ErrNotFound)
+		}
+		return w.Value, nil
+	}
+	return tx.get(bucket, key)
+}
+
+func (tx *TxBuffer) Scan(bucket, prefix, cursor string, limit int) ([]KeyValue, string, error) {
+	return tx.scan(bucket, prefix, cursor, limit, tx.Pending[bucket])
+}
+
+// buffer the write within the transaction
+func (tx *TxBuffer) buffer(bucket, key string, w Write) error {
+	if err := tx.assertBucket(bucket); err != nil {
+		return err
+	}
+	if _, ok := tx.Pending[bucket]; !ok {
+		tx.Pending[bucket] = make(map[string]Write)
+	}
+	tx.Pending[bucket][key] = w
+	return nil
+}
+
+// Overlay returns the keys written by the pending writes of a bucket for SortedKeys.Scan
+func Overlay(pending map[string]Write) map[string]bool {
+	overlay := make(map[string]bool, len(pending))
+	for key, w := range pending {
+		overlay[key] = !w.Deleted
+	}
+	return overlay
+}
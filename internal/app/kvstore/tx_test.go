@@ -0,0 +1,54 @@
+package kvstore_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxBuffer(t *testing.T) {
+	committed := map[string][]byte{"a": []byte("committed-a"), "b": []byte("committed-b")}
+	var scanned map[string]kvstore.Write
+	sut := kvstore.NewTxBuffer(
+		func(bucket string) error {
+			if bucket != "_index" {
+				return fmt.Errorf("bucket (%s) not found", bucket)
+			}
+			return nil
+		},
+		func(bucket, key string) ([]byte, error) {
+			if v, ok := committed[key]; ok {
+				return v, nil
+			}
+			return nil, fmt.Errorf("key (%s:%s) %w", bucket, key, kvstore.ErrNotFound)
+		},
+		func(_, _, _ string, _ int, pending map[string]kvstore.Write) ([]kvstore.KeyValue, string, error) {
+			scanned = pending
+			return nil, "", nil
+		})
+
+	assert.NoError(t, sut.Put("_index", "a", []byte("pending-a")))
+	assert.NoError(t, sut.Delete("_index", "b"))
+	assert.NoError(t, sut.Put("_index", "c", []byte("pending-c")))
+	assert.EqualError(t, sut.Put("foo", "a", nil), "bucket (foo) not found")
+
+	v, err := sut.Get("_index", "a")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pending-a"), v)
+	_, err = sut.Get("_index", "b")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
+	_, err = sut.Get("_index", "d")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
+
+	_, _, err = sut.Scan("_index", "", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, sut.Pending["_index"], scanned)
+	assert.Equal(t, map[string]bool{"a": true, "b": false, "c": true}, kvstore.Overlay(scanned))
+	assert.Equal(t, kvstore.Writes{"_index": {
+		"a": {Value: []byte("pending-a")},
+		"b": {Deleted: true},
+		"c": {Value: []byte("pending-c")},
+	}}, sut.Pending)
+}
@@ -0,0 +1,477 @@
+package filekv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+)
+
+var (
+	_ kvstore.KeyValueStore = (*FileKVS)(nil)
+	_ kvstore.Compactor     = (*FileKVS)(nil)
+)
+
+const (
+	frameHeaderSize    = 8    // length and checksum of the payload, 4 bytes each
+	compactedFrameSize = 1000 // max count of records per frame written by Compact
+	opPut              = byte(1)
+	opDelete           = byte(2)
+)
+
+// SyncPolicy defines when written data is flushed to stable storage
+type SyncPolicy uint8
+
+const (
+	SyncAlways   SyncPolicy = iota // fsync after every write or transaction
+	SyncInterval                   // fsync periodically, see WithSyncInterval
+	SyncNever                      // leave flushing to the operating system
+)
+
+type FileKVSOption func(*FileKVS)
+
+// location of a value within the file
+type location struct {
+	offset int64
+	size   int
+}
+
+// FileKVS is a durable key-value-store persisting all writes to an append-only file and keeping an index of all keys
+// in memory. Each write or transaction is appended as one checksummed frame, so a frame torn by a crash is detected
+// and discarded on open.
+type FileKVS struct {
+	sync.RWMutex
+	path     string
+	file     *os.File
+	size     int64
+	index    map[string]map[string]location // bucket, key, location of value
+	keys     map[string]kvstore.SortedKeys  // keys of each bucket in ascending order
+	policy   SyncPolicy
+	interval time.Duration
+	done     chan struct{}
+	closed   bool
+	txMu     sync.Mutex // serializes transactions
+}
+
+// NewFileKVS opens or creates the file at path, recovers the index from its content and asserts the buckets
+func NewFileKVS(path string, buckets []string, opts ...FileKVSOption) (*FileKVS, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f := &FileKVS{
+		path:     path,
+		file:     file,
+		index:    make(map[string]map[string]location),
+		keys:     make(map[string]kvstore.SortedKeys),
+		policy:   SyncAlways,
+		interval: time.Second,
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if err := f.recover(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	f.assertBuckets(buckets...)
+	if f.policy == SyncInterval {
+		go f.syncPeriodically()
+	}
+	return f, nil
+}
+
+func WithSyncPolicy(policy SyncPolicy) FileKVSOption {
+	return func(f *FileKVS) {
+		f.policy = policy
+	}
+}
+
+// WithSyncInterval flushes written data periodically instead of after every write
+func WithSyncInterval(interval time.Duration) FileKVSOption {
+	return func(f *FileKVS) {
+		f.policy = SyncInterval
+		f.interval = interval
+	}
+}
+
+func (f *FileKVS) AssertBucket(bucket string) error {
+	if _, ok := f.index[bucket]; !ok {
+		return fmt.Errorf("bucket (%s) not found", bucket)
+	}
+	return nil
+}
+
+// assertBucket runs AssertBucket under the read lock for the writes of transactions
+func (f *FileKVS) assertBucket(bucket string) error {
+	f.RLock()
+	defer f.RUnlock()
+	return f.AssertBucket(bucket)
+}
+
+func (f *FileKVS) Put(bucket, key string, value []byte) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.AssertBucket(bucket); err != nil {
+		return err
+	}
+	return f.write(kvstore.Writes{bucket: {key: {Value: value}}})
+}
+
+func (f *FileKVS) Delete(bucket, key string) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.AssertBucket(bucket); err != nil {
+		return err
+	}
+	if _, ok := f.index[bucket][key]; !ok {
+		return nil
+	}
+	return f.write(kvstore.Writes{bucket: {key: {Deleted: true}}})
+}
+
+func (f *FileKVS) Get(bucket, key string) ([]byte, error) {
+	f.RLock()
+	defer f.RUnlock()
+	if err := f.AssertBucket(bucket); err != nil {
+		return nil, err
+	}
+	loc, ok := f.index[bucket][key]
+	if !ok {
+		return nil, fmt.Errorf("key (%s:%s) %w", bucket, key, kvstore.ErrNotFound)
+	}
+	value := make([]byte, loc.size)
+	if _, err := f.file.ReadAt(value, loc.offset); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Scan returns the keys of the bucket in ascending order, see kvstore.KeyValueStore
+func (f *FileKVS) Scan(bucket, prefix, cursor string, limit int) ([]kvstore.KeyValue, string, error) {
+	return f.scan(bucket, prefix, cursor, limit, nil)
+}
+
+// scan returns the keys of the bucket in ascending order including the pending writes of a transaction
+func (f *FileKVS) scan(bucket, prefix, cursor string, limit int, pending map[string]kvstore.Write) ([]kvstore.KeyValue, string, error) {
+	f.RLock()
+	defer f.RUnlock()
+	if err := f.AssertBucket(bucket); err != nil {
+		return nil, "", err
+	}
+	keys, next := f.keys[bucket].Scan(prefix, cursor, limit, kvstore.Overlay(pending))
+	result := make([]kvstore.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		if w, ok := pending[key]; ok {
+			result = append(result, kvstore.KeyValue{Key: key, Value: w.Value})
+			continue
+		}
+		loc := f.index[bucket][key]
+		value := make([]byte, loc.size)
+		if _, err := f.file.ReadAt(value, loc.offset); err != nil {
+			return nil, "", err
+		}
+		result = append(result, kvstore.KeyValue{Key: key, Value: value})
+	}
+	return result, next, nil
+}
+
+// WithTx runs all functions within one transaction. The buffered writes are appended as one frame if all functions
+// succeed and discarded otherwise.
+func (f *FileKVS) WithTx(fn ...func(tx kvstore.ReadWriter) error) error {
+	f.txMu.Lock()
+	defer f.txMu.Unlock()
+	tx := kvstore.NewTxBuffer(f.assertBucket, f.Get, f.scan)
+	for _, fun := range fn {
+		if err := fun(tx); err != nil {
+			return err
+		}
+	}
+	f.Lock()
+	defer f.Unlock()
+	return f.write(tx.Pending)
+}
+
+// Rollback is a no-op since WithTx already discards the writes of failed transactions
+func (f *FileKVS) Rollback() {
+	// empty on purpose
+}
+
+// Compact rewrites the file with the current values only, dropping overwritten values and deleted keys. The
+// compacted file replaces the file once it is written completely.
+func (f *FileKVS) Compact() (int64, error) {
+	f.txMu.Lock()
+	defer f.txMu.Unlock()
+	f.Lock()
+	defer f.Unlock()
+	file, err := os.OpenFile(f.path+".compact", os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return 0, err
+	}
+	compacted := &FileKVS{file: file, index: make(map[string]map[string]location), keys: make(map[string]kvstore.SortedKeys),
+		policy: SyncNever}
+	if err := f.copyTo(compacted); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return 0, err
+	}
+	if err := os.Rename(file.Name(), f.path); err != nil {
+		_ = file.Close()
+		return 0, err
+	}
+	// the rename is only durable once the directory is flushed, otherwise a crash may bring back the replaced file
+	if err := syncDir(f.path); err != nil {
+		_ = file.Close()
+		return 0, err
+	}
+	if err := f.file.Close(); err != nil {
+		log.Printf("[WARN]\t %T.Compact - close replaced file failed: %s", f, err)
+	}
+	freed := f.size - compacted.size
+	f.file, f.size, f.index, f.keys = file, compacted.size, compacted.index, compacted.keys
+	log.Printf("[INFO]\t %T.Compact - compacted %s: %d bytes freed", f, f.path, freed)
+	return freed, nil
+}
+
+// copyTo writes the current values to the store in frames of up to compactedFrameSize records. The caller must hold
+// the lock.
+func (f *FileKVS) copyTo(to *FileKVS) error {
+	for bucket, kv := range f.index {
+		to.index[bucket] = make(map[string]location)
+		frame := kvstore.Writes{bucket: {}}
+		// copying in the order of the keys keeps inserting into the sorted keys cheap
+		for _, key := range f.keys[bucket] {
+			loc := kv[key]
+			value := make([]byte, loc.size)
+			if _, err := f.file.ReadAt(value, loc.offset); err != nil {
+				return err
+			}
+			frame[bucket][key] = kvstore.Write{Value: value}
+			if len(frame[bucket]) == compactedFrameSize {
+				if err := to.write(frame); err != nil {
+					return err
+				}
+				frame[bucket] = make(map[string]kvstore.Write)
+			}
+		}
+		if err := to.write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes all written data and closes the file, closing a closed store is a no-op
+func (f *FileKVS) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	close(f.done)
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	return f.file.Close()
+}
+
+// write appends the writes as one frame and updates the index. The caller must hold the lock.
+func (f *FileKVS) write(writes kvstore.Writes) error {
+	payload := make([]byte, 0)
+	locations := make(map[string]map[string]*location) // nil locations are deleted
+	for bucket, kv := range writes {
+		locations[bucket] = make(map[string]*location)
+		for key, w := range kv {
+			if w.Deleted {
+				payload = append(payload, opDelete)
+			} else {
+				payload = append(payload, opPut)
+			}
+			payload = binary.AppendUvarint(payload, uint64(len(bucket)))
+			payload = append(payload, bucket...)
+			payload = binary.AppendUvarint(payload, uint64(len(key)))
+			payload = append(payload, key...)
+			if w.Deleted {
+				locations[bucket][key] = nil
+				continue
+			}
+			payload = binary.AppendUvarint(payload, uint64(len(w.Value)))
+			locations[bucket][key] = &location{f.size + frameHeaderSize + int64(len(payload)), len(w.Value)}
+			payload = append(payload, w.Value...)
+		}
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	frame = append(frame, payload...)
+	if _, err := f.file.WriteAt(frame, f.size); err != nil {
+		return err
+	}
+	if f.policy == SyncAlways {
+		if err := f.file.Sync(); err != nil {
+			return err
+		}
+	}
+	f.size += int64(len(frame))
+	for bucket, kv := range locations {
+		for key, loc := range kv {
+			if loc == nil {
+				f.remove(bucket, key)
+				continue
+			}
+			f.locate(bucket, key, *loc)
+		}
+	}
+	return nil
+}
+
+// recover rebuilds the index from all frames of the file. A torn frame at the end of the file is truncated, a corrupt
+// frame followed by further data fails the recovery since truncating it would discard committed writes.
+func (f *FileKVS) recover() error {
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(f.file)
+	header := make([]byte, frameHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return f.truncate(err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		end := f.size + frameHeaderSize + size
+		if end > info.Size() {
+			return f.truncate(io.ErrUnexpectedEOF)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			if end == info.Size() {
+				return f.truncate(errors.New("checksum mismatch"))
+			}
+			return fmt.Errorf("corrupt frame at offset %d of %s: checksum mismatch", f.size, f.path)
+		}
+		if err := f.replay(payload); err != nil {
+			return fmt.Errorf("corrupt frame at offset %d of %s: %w", f.size, f.path, err)
+		}
+		f.size = end
+	}
+}
+
+// replay applies the records of a frame payload to the index
+func (f *FileKVS) replay(payload []byte) error {
+	offset := f.size + frameHeaderSize
+	for pos := 0; pos < len(payload); {
+		op := payload[pos]
+		if op != opPut && op != opDelete {
+			return fmt.Errorf("unknown operation %d", op)
+		}
+		pos++
+		fields := make([][]byte, 3)
+		if op == opDelete {
+			fields = fields[:2]
+		}
+		for i := range fields {
+			n, read := binary.Uvarint(payload[pos:])
+			if read <= 0 || pos+read+int(n) > len(payload) {
+				return errors.New("corrupt record")
+			}
+			pos += read
+			fields[i] = payload[pos : pos+int(n)]
+			if i < 2 {
+				pos += int(n)
+			}
+		}
+		bucket, key := string(fields[0]), string(fields[1])
+		if _, ok := f.index[bucket]; !ok {
+			f.index[bucket] = make(map[string]location)
+		}
+		if op == opDelete {
+			f.remove(bucket, key)
+			continue
+		}
+		f.locate(bucket, key, location{offset + int64(pos), len(fields[2])})
+		pos += len(fields[2])
+	}
+	return nil
+}
+
+// locate the value of the key, the caller must hold the lock
+func (f *FileKVS) locate(bucket, key string, loc location) {
+	if _, ok := f.index[bucket][key]; !ok {
+		f.keys[bucket] = f.keys[bucket].Insert(key)
+	}
+	f.index[bucket][key] = loc
+}
+
+// remove the key from the index, the caller must hold the lock
+func (f *FileKVS) remove(bucket, key string) {
+	if _, ok := f.index[bucket][key]; ok {
+		f.keys[bucket] = f.keys[bucket].Remove(key)
+	}
+	delete(f.index[bucket], key)
+}
+
+func (f *FileKVS) truncate(cause error) error {
+	log.Printf("[WARN]\t %T.recover - discard torn frame at offset %d: %s", f, f.size, cause)
+	return f.file.Truncate(f.size)
+}
+
+func (f *FileKVS) syncPeriodically() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			f.RLock()
+			if err := f.file.Sync(); err != nil {
+				log.Printf("[ERROR]\t %T.syncPeriodically - sync failed: %s", f, err)
+			}
+			f.RUnlock()
+		}
+	}
+}
+
+func (f *FileKVS) assertBuckets(IDs ...string) {
+	defer log.Printf("assert buckets: %+v", IDs)
+	for _, ID := range IDs {
+		if _, ok := f.index[ID]; !ok {
+			f.index[ID] = make(map[string]location)
+		}
+	}
+}
+
+// syncDir flushes the directory of the file, making the creation or renaming of the file durable
+func syncDir(path string) error {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,265 @@
+package filekv_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+	"github.com/openyard/eventstore/pkg/kvstore/filekv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFileKVS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "eventstore.db")
+	sut, err := filekv.NewFileKVS(path, []string{"_index", "_meta", "_content"}, filekv.WithSyncInterval(time.Millisecond))
+	require.NoError(t, err)
+	assert.NoError(t, sut.AssertBucket("_index"))
+	assert.NoError(t, sut.AssertBucket("_meta"))
+	assert.NoError(t, sut.AssertBucket("_content"))
+
+	v, err := sut.Get("_index", "foo")
+	assert.Empty(t, v)
+	assert.EqualError(t, err, "key (_index:foo) not found")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
+	assert.Equal(t, fmt.Errorf("bucket (foo) not found"), sut.Put("foo", "bar", []byte("baz")))
+
+	assert.NoError(t, sut.Put("_index", "foo", []byte("bar")))
+	assert.NoError(t, sut.Put("_index", "foo", []byte("baz")))
+	assert.NoError(t, sut.Put("_content", "foo", nil))
+	require.NoError(t, sut.Close())
+	assert.NoError(t, sut.Close())
+
+	sut, err = filekv.NewFileKVS(path, []string{"_index", "_meta", "_content"})
+	require.NoError(t, err)
+	defer sut.Close()
+	v, err = sut.Get("_index", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "baz", string(v))
+	v, err = sut.Get("_content", "foo")
+	assert.NoError(t, err)
+	assert.Empty(t, v)
+}
+
+func TestFileKVS_WithTx(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "eventstore.db")
+	sut, err := filekv.NewFileKVS(path, []string{"_index", "_content"}, filekv.WithSyncPolicy(filekv.SyncNever))
+	require.NoError(t, err)
+
+	assert.NoError(t, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		if err := tx.Put("_index", "stream-1", []byte{1}); err != nil {
+			return err
+		}
+		v, err := tx.Get("_index", "stream-1")
+		assert.Equal(t, []byte{1}, v)
+		return err
+	}, func(tx kvstore.ReadWriter) error {
+		return tx.Put("_content", "stream-1", []byte("event"))
+	}))
+
+	failure := errors.New("failure")
+	assert.Equal(t, failure, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		return tx.Put("_index", "stream-1", []byte{2})
+	}, func(tx kvstore.ReadWriter) error {
+		return failure
+	}))
+	sut.Rollback()
+	v, err := sut.Get("_index", "stream-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1}, v)
+	require.NoError(t, sut.Close())
+
+	sut, err = filekv.NewFileKVS(path, []string{"_index", "_content"})
+	require.NoError(t, err)
+	defer sut.Close()
+	v, err = sut.Get("_content", "stream-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "event", string(v))
+}
+
+func TestFileKVS_RecoverTornFrame(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "eventstore.db")
+	sut, err := filekv.NewFileKVS(path, []string{"_index"}, filekv.WithSyncInterval(time.Millisecond))
+	require.NoError(t, err)
+	assert.NoError(t, sut.Put("_index", "foo", []byte("bar")))
+	require.NoError(t, sut.Close())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0x00, 0x00, 0x00, 0x20, 0x01, 0x02})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	sut, err = filekv.NewFileKVS(path, []string{"_index"})
+	require.NoError(t, err)
+	defer sut.Close()
+	recovered, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), recovered.Size())
+	v, err := sut.Get("_index", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", string(v))
+
+	assert.NoError(t, sut.Put("_index", "foo", []byte("baz")))
+	v, err = sut.Get("_index", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "baz", string(v))
+}
+
+func TestFileKVS_RecoverCorruptFrame(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "eventstore.db")
+	sut, err := filekv.NewFileKVS(path, []string{"_index"})
+	require.NoError(t, err)
+	assert.NoError(t, sut.Put("_index", "foo", []byte("bar")))
+	assert.NoError(t, sut.Put("_index", "foo", []byte("baz")))
+	require.NoError(t, sut.Close())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	raw[len(raw)/2-1] ^= 0xff // the payload of the first frame
+	require.NoError(t, os.WriteFile(path, raw, 0644))
+
+	_, err = filekv.NewFileKVS(path, []string{"_index"})
+	assert.ErrorContains(t, err, "corrupt frame at offset 0")
+	recovered, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), recovered.Size())
+}
+
+func TestFileKVS_WithTxIsIsolated(t *testing.T) {
+	sut, err := filekv.NewFileKVS(filepath.Join(t.TempDir(), "eventstore.db"), []string{"_index", "_subscriptions"})
+	require.NoError(t, err)
+	defer sut.Close()
+	failure := errors.New("failure")
+
+	assert.Equal(t, failure, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		if err := tx.Put("_index", "stream-1", []byte{1}); err != nil {
+			return err
+		}
+		_, err := sut.Get("_index", "stream-1")
+		assert.ErrorIs(t, err, kvstore.ErrNotFound, "uncommitted write visible outside the transaction")
+		kvs, _, err := sut.Scan("_index", "", "", 0)
+		assert.NoError(t, err)
+		assert.Empty(t, kvs, "uncommitted write visible to scans outside the transaction")
+		assert.NoError(t, sut.Put("_subscriptions", "sub-1", []byte{2}))
+		return failure
+	}))
+	v, err := sut.Get("_subscriptions", "sub-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2}, v)
+	_, err = sut.Get("_index", "stream-1")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
+}
+
+func TestFileKVS_Delete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "eventstore.db")
+	sut, err := filekv.NewFileKVS(path, []string{"_index"})
+	require.NoError(t, err)
+	assert.NoError(t, sut.Put("_index", "stream-1", []byte{1}))
+	assert.NoError(t, sut.Put("_index", "stream-2", []byte{2}))
+	assert.NoError(t, sut.Put("_index", "stream-3", []byte{3}))
+	assert.NoError(t, sut.Delete("_index", "stream-1"))
+	assert.NoError(t, sut.Delete("_index", "missing"))
+	assert.Equal(t, fmt.Errorf("bucket (foo) not found"), sut.Delete("foo", "bar"))
+	assert.NoError(t, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		if err := tx.Delete("_index", "stream-2"); err != nil {
+			return err
+		}
+		_, err := tx.Get("_index", "stream-2")
+		assert.ErrorIs(t, err, kvstore.ErrNotFound)
+		return nil
+	}))
+	require.NoError(t, sut.Close())
+
+	sut, err = filekv.NewFileKVS(path, []string{"_index"})
+	require.NoError(t, err)
+	defer sut.Close()
+	for _, key := range []string{"stream-1", "stream-2"} {
+		_, err = sut.Get("_index", key)
+		assert.ErrorIs(t, err, kvstore.ErrNotFound)
+	}
+	v, err := sut.Get("_index", "stream-3")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{3}, v)
+}
+
+func TestFileKVS_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "eventstore.db")
+	sut, err := filekv.NewFileKVS(path, []string{"_index", "_content"})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, sut.Put("_index", "stream-1", []byte{byte(i)}))
+		assert.NoError(t, sut.Put("_content", fmt.Sprintf("stream-1/%d", i), []byte("event")))
+	}
+	assert.NoError(t, sut.Delete("_content", "stream-1/0"))
+	before, err := os.Stat(path)
+	require.NoError(t, err)
+
+	freed, err := sut.Compact()
+	require.NoError(t, err)
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, before.Size()-after.Size(), freed)
+	assert.Positive(t, freed)
+	assert.NoError(t, sut.Put("_content", "stream-1/10", []byte("event")))
+	require.NoError(t, sut.Close())
+
+	sut, err = filekv.NewFileKVS(path, []string{"_index", "_content"})
+	require.NoError(t, err)
+	defer sut.Close()
+	v, err := sut.Get("_index", "stream-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{9}, v)
+	_, err = sut.Get("_content", "stream-1/0")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
+	for i := 1; i <= 10; i++ {
+		v, err = sut.Get("_content", fmt.Sprintf("stream-1/%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, "event", string(v))
+	}
+}
+
+func TestFileKVS_Scan(t *testing.T) {
+	sut, err := filekv.NewFileKVS(filepath.Join(t.TempDir(), "eventstore.db"), []string{"_content"}, filekv.WithSyncPolicy(filekv.SyncNever))
+	require.NoError(t, err)
+	defer sut.Close()
+	for _, key := range []string{"stream-2/02", "stream-1/01", "other", "stream-1/02", "stream-1/03"} {
+		assert.NoError(t, sut.Put("_content", key, []byte(key)))
+	}
+
+	kvs, next, err := sut.Scan("_content", "stream-1/", "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []kvstore.KeyValue{{Key: "stream-1/01", Value: []byte("stream-1/01")}, {Key: "stream-1/02", Value: []byte("stream-1/02")}}, kvs)
+	assert.Equal(t, "stream-1/02", next)
+	kvs, next, err = sut.Scan("_content", "stream-1/", next, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []kvstore.KeyValue{{Key: "stream-1/03", Value: []byte("stream-1/03")}}, kvs)
+	assert.Empty(t, next)
+
+	assert.NoError(t, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		if err := tx.Delete("_content", "stream-1/01"); err != nil {
+			return err
+		}
+		if err := tx.Put("_content", "stream-1/04", []byte("new")); err != nil {
+			return err
+		}
+		kvs, next, err := tx.Scan("_content", "", "other", 0)
+		keys := make([]string, 0, len(kvs))
+		for _, kv := range kvs {
+			keys = append(keys, kv.Key)
+		}
+		assert.Equal(t, []string{"stream-1/02", "stream-1/03", "stream-1/04", "stream-2/02"}, keys)
+		assert.Empty(t, next)
+		return err
+	}))
+	_, _, err = sut.Scan("foo", "", "", 0)
+	assert.Error(t, err)
+}
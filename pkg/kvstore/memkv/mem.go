@@ -8,18 +8,19 @@ import (
 	"github.com/openyard/eventstore/internal/app/kvstore"
 )
 
-var (
-	_    kvstore.KeyValueStore = (*MemoryKVS)(nil)
-	zero                       = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
-)
+var _ kvstore.KeyValueStore = (*MemoryKVS)(nil)
 
+// MemoryKVS keeps all buckets in memory. Writes within a transaction started with WithTx are buffered by the
+// transaction and applied atomically once all functions of the transaction succeeded.
 type MemoryKVS struct {
 	sync.RWMutex
-	buckets map[string]map[string][]byte // bucket, key, value
+	buckets map[string]map[string][]byte  // bucket, key, value
+	keys    map[string]kvstore.SortedKeys // keys of each bucket in ascending order
+	txMu    sync.Mutex                    // serializes transactions
 }
 
 func NewMemoryKVS(buckets ...string) *MemoryKVS {
-	memKVS := &MemoryKVS{buckets: make(map[string]map[string][]byte)}
+	memKVS := &MemoryKVS{buckets: make(map[string]map[string][]byte), keys: make(map[string]kvstore.SortedKeys)}
 	memKVS.assertBuckets(buckets...)
 	return memKVS
 }
@@ -31,42 +32,119 @@ func (m *MemoryKVS) AssertBucket(bucket string) error {
 	return nil
 }
 
+// assertBucket runs AssertBucket under the read lock for the writes of transactions
+func (m *MemoryKVS) assertBucket(bucket string) error {
+	m.RLock()
+	defer m.RUnlock()
+	return m.AssertBucket(bucket)
+}
+
 func (m *MemoryKVS) Put(bucket, key string, value []byte) error {
 	m.Lock()
 	defer m.Unlock()
 	if err := m.AssertBucket(bucket); err != nil {
 		return err
 	}
-	m.buckets[bucket][key] = value
+	m.put(bucket, key, value)
 	return nil
 }
 
-func (m *MemoryKVS) Get(bucket, key string) ([]byte, error) {
+func (m *MemoryKVS) Delete(bucket, key string) error {
 	m.Lock()
 	defer m.Unlock()
 	if err := m.AssertBucket(bucket); err != nil {
-		return zero, err
+		return err
+	}
+	m.delete(bucket, key)
+	return nil
+}
+
+func (m *MemoryKVS) Get(bucket, key string) ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if err := m.AssertBucket(bucket); err != nil {
+		return nil, err
 	}
 	if value, ok := m.buckets[bucket][key]; ok {
 		return value, nil
 	}
-	return zero, fmt.Errorf("key (%s:%s) not found", bucket, key)
+	return nil, fmt.Errorf("key (%s:%s) %w", bucket, key, kvstore.ErrNotFound)
+}
+
+// Scan returns the keys of the bucket in ascending order, see kvstore.KeyValueStore
+func (m *MemoryKVS) Scan(bucket, prefix, cursor string, limit int) ([]kvstore.KeyValue, string, error) {
+	return m.scan(bucket, prefix, cursor, limit, nil)
+}
+
+// scan returns the keys of the bucket in ascending order including the pending writes of a transaction
+func (m *MemoryKVS) scan(bucket, prefix, cursor string, limit int, pending map[string]kvstore.Write) ([]kvstore.KeyValue, string, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if err := m.AssertBucket(bucket); err != nil {
+		return nil, "", err
+	}
+	keys, next := m.keys[bucket].Scan(prefix, cursor, limit, kvstore.Overlay(pending))
+	result := make([]kvstore.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		value := m.buckets[bucket][key]
+		if w, ok := pending[key]; ok {
+			value = w.Value
+		}
+		result = append(result, kvstore.KeyValue{Key: key, Value: value})
+	}
+	return result, next, nil
 }
 
+// Rollback is a no-op since WithTx already discards the writes of failed transactions
 func (m *MemoryKVS) Rollback() {
 	// empty on purpose
 }
 
-func (m *MemoryKVS) WithTx(fn ...func() error) error {
+// WithTx runs all functions within one transaction. The buffered writes are applied if all functions succeed and
+// discarded otherwise.
+func (m *MemoryKVS) WithTx(fn ...func(tx kvstore.ReadWriter) error) error {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	tx := kvstore.NewTxBuffer(m.assertBucket, m.Get, m.scan)
 	for _, f := range fn {
-
-		if err := f(); err != nil {
+		if err := f(tx); err != nil {
 			return err
 		}
 	}
+	m.commit(tx.Pending)
 	return nil
 }
 
+func (m *MemoryKVS) commit(pending kvstore.Writes) {
+	m.Lock()
+	defer m.Unlock()
+	for bucket, writes := range pending {
+		for key, w := range writes {
+			if w.Deleted {
+				m.delete(bucket, key)
+				continue
+			}
+			m.put(bucket, key, w.Value)
+		}
+	}
+}
+
+// put the value of the key, the caller must hold the lock
+func (m *MemoryKVS) put(bucket, key string, value []byte) {
+	if _, ok := m.buckets[bucket][key]; !ok {
+		m.keys[bucket] = m.keys[bucket].Insert(key)
+	}
+	m.buckets[bucket][key] = value
+}
+
+// delete the key, the caller must hold the lock
+func (m *MemoryKVS) delete(bucket, key string) {
+	if _, ok := m.buckets[bucket][key]; ok {
+		m.keys[bucket] = m.keys[bucket].Remove(key)
+	}
+	delete(m.buckets[bucket], key)
+}
+
 func (m *MemoryKVS) assertBuckets(IDs ...string) {
 	defer log.Printf("assert buckets: %+v", IDs)
 	for _, ID := range IDs {
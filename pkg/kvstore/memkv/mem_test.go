@@ -1,9 +1,11 @@
 package memkv_test
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
+	"github.com/openyard/eventstore/internal/app/kvstore"
 	"github.com/openyard/eventstore/pkg/kvstore/memkv"
 	"github.com/stretchr/testify/assert"
 )
@@ -16,7 +18,8 @@ func TestNewMemoryKVS(t *testing.T) {
 
 	v, err := sut.Get("_index", "foo")
 	assert.Empty(t, v)
-	assert.Equal(t, fmt.Errorf("key (_index:foo) not found"), err)
+	assert.EqualError(t, err, "key (_index:foo) not found")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
 
 	v, err = sut.Get("foo", "bar")
 	assert.Empty(t, v)
@@ -29,3 +32,126 @@ func TestNewMemoryKVS(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "bar", string(v))
 }
+
+func TestMemoryKVS_WithTx(t *testing.T) {
+	sut := memkv.NewMemoryKVS("_index", "_content")
+
+	assert.NoError(t, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		if err := tx.Put("_index", "stream-1", []byte{1}); err != nil {
+			return err
+		}
+		v, err := tx.Get("_index", "stream-1")
+		assert.Equal(t, []byte{1}, v)
+		return err
+	}, func(tx kvstore.ReadWriter) error {
+		return tx.Put("_content", "stream-1", []byte("event"))
+	}))
+	v, err := sut.Get("_content", "stream-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "event", string(v))
+
+	failure := errors.New("failure")
+	assert.Equal(t, failure, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		return tx.Put("_index", "stream-1", []byte{2})
+	}, func(kvstore.ReadWriter) error {
+		return failure
+	}))
+	sut.Rollback()
+	v, err = sut.Get("_index", "stream-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1}, v)
+}
+
+func TestMemoryKVS_WithTxIsIsolated(t *testing.T) {
+	sut := memkv.NewMemoryKVS("_index", "_subscriptions")
+	failure := errors.New("failure")
+
+	assert.Equal(t, failure, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		if err := tx.Put("_index", "stream-1", []byte{1}); err != nil {
+			return err
+		}
+		_, err := sut.Get("_index", "stream-1")
+		assert.ErrorIs(t, err, kvstore.ErrNotFound, "uncommitted write visible outside the transaction")
+		kvs, _, err := sut.Scan("_index", "", "", 0)
+		assert.NoError(t, err)
+		assert.Empty(t, kvs, "uncommitted write visible to scans outside the transaction")
+		// a write outside the transaction is neither joined to it nor lost with it
+		assert.NoError(t, sut.Put("_subscriptions", "sub-1", []byte{2}))
+		return failure
+	}))
+	v, err := sut.Get("_subscriptions", "sub-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2}, v)
+	_, err = sut.Get("_index", "stream-1")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
+}
+
+func TestMemoryKVS_Delete(t *testing.T) {
+	sut := memkv.NewMemoryKVS("_index")
+	assert.NoError(t, sut.Put("_index", "stream-1", []byte{1}))
+	assert.NoError(t, sut.Put("_index", "stream-2", []byte{2}))
+	assert.NoError(t, sut.Delete("_index", "stream-1"))
+	assert.NoError(t, sut.Delete("_index", "missing"))
+	assert.Equal(t, fmt.Errorf("bucket (foo) not found"), sut.Delete("foo", "bar"))
+	_, err := sut.Get("_index", "stream-1")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
+
+	failure := errors.New("failure")
+	assert.Equal(t, failure, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		return tx.Delete("_index", "stream-2")
+	}, func(kvstore.ReadWriter) error {
+		return failure
+	}))
+	v, err := sut.Get("_index", "stream-2")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2}, v)
+
+	assert.NoError(t, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		if err := tx.Delete("_index", "stream-2"); err != nil {
+			return err
+		}
+		_, err := tx.Get("_index", "stream-2")
+		assert.ErrorIs(t, err, kvstore.ErrNotFound)
+		return tx.Put("_index", "stream-1", []byte{3})
+	}))
+	_, err = sut.Get("_index", "stream-2")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
+	v, err = sut.Get("_index", "stream-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{3}, v)
+}
+
+func TestMemoryKVS_Scan(t *testing.T) {
+	sut := memkv.NewMemoryKVS("_content")
+	for _, key := range []string{"stream-2/02", "stream-1/01", "other", "stream-1/02", "stream-1/03"} {
+		assert.NoError(t, sut.Put("_content", key, []byte(key)))
+	}
+
+	kvs, next, err := sut.Scan("_content", "stream-1/", "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []kvstore.KeyValue{{Key: "stream-1/01", Value: []byte("stream-1/01")}, {Key: "stream-1/02", Value: []byte("stream-1/02")}}, kvs)
+	assert.Equal(t, "stream-1/02", next)
+	kvs, next, err = sut.Scan("_content", "stream-1/", next, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []kvstore.KeyValue{{Key: "stream-1/03", Value: []byte("stream-1/03")}}, kvs)
+	assert.Empty(t, next)
+
+	assert.NoError(t, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		if err := tx.Delete("_content", "stream-1/01"); err != nil {
+			return err
+		}
+		if err := tx.Put("_content", "stream-1/04", []byte("new")); err != nil {
+			return err
+		}
+		kvs, next, err := tx.Scan("_content", "", "other", 0)
+		keys := make([]string, 0, len(kvs))
+		for _, kv := range kvs {
+			keys = append(keys, kv.Key)
+		}
+		assert.Equal(t, []string{"stream-1/02", "stream-1/03", "stream-1/04", "stream-2/02"}, keys)
+		assert.Empty(t, next)
+		return err
+	}))
+	_, _, err = sut.Scan("foo", "", "", 0)
+	assert.Error(t, err)
+}
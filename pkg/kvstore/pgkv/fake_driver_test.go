@@ -0,0 +1,238 @@
+package pgkv_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// fakeDriver is a pure-Go stand-in for postgres understanding the statements issued by PostgresKVS.
+// Writes within a transaction are buffered per connection and applied on commit.
+type fakeDriver struct {
+	sync.Mutex
+	tables map[string]map[string][]byte
+}
+
+func openFakeDB(name string) *sql.DB {
+	sql.Register(name, &fakeDriver{tables: make(map[string]map[string][]byte)})
+	db, err := sql.Open(name, "")
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+func (d *fakeDriver) Open(_ string) (driver.Conn, error) {
+	return &fakeConn{driver: d}, nil
+}
+
+type fakeConn struct {
+	driver  *fakeDriver
+	pending map[string]map[string][]byte // nil if no transaction is running, nil values are deleted on commit
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.pending = make(map[string]map[string][]byte)
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.driver.Lock()
+	defer c.driver.Unlock()
+	for table, values := range c.pending {
+		for k, v := range values {
+			if v == nil {
+				delete(c.driver.tables[table], k)
+				continue
+			}
+			c.driver.tables[table][k] = v
+		}
+	}
+	c.pending = nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.pending = nil
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return strings.Count(s.query, "$")
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	d := s.conn.driver
+	d.Lock()
+	defer d.Unlock()
+	table := tableOf(s.query)
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE IF NOT EXISTS"):
+		if _, ok := d.tables[table]; !ok {
+			d.tables[table] = make(map[string][]byte)
+		}
+	case strings.HasPrefix(s.query, "INSERT INTO"):
+		if _, ok := d.tables[table]; !ok {
+			return nil, fmt.Errorf("relation %q does not exist", table)
+		}
+		if s.conn.pending == nil {
+			d.tables[table][args[0].(string)] = args[1].([]byte)
+			break
+		}
+		if _, ok := s.conn.pending[table]; !ok {
+			s.conn.pending[table] = make(map[string][]byte)
+		}
+		s.conn.pending[table][args[0].(string)] = args[1].([]byte)
+	case strings.HasPrefix(s.query, "ALTER TABLE"):
+		if _, ok := d.tables[table]; !ok {
+			return nil, fmt.Errorf("relation %q does not exist", table)
+		}
+	case strings.HasPrefix(s.query, "VACUUM"):
+		if _, ok := d.tables[table]; !ok {
+			return nil, fmt.Errorf("relation %q does not exist", table)
+		}
+	case strings.HasPrefix(s.query, "DELETE FROM"):
+		if _, ok := d.tables[table]; !ok {
+			return nil, fmt.Errorf("relation %q does not exist", table)
+		}
+		if s.conn.pending == nil {
+			delete(d.tables[table], args[0].(string))
+			break
+		}
+		if _, ok := s.conn.pending[table]; !ok {
+			s.conn.pending[table] = make(map[string][]byte)
+		}
+		s.conn.pending[table][args[0].(string)] = nil
+	default:
+		return nil, fmt.Errorf("unsupported statement: %s", s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	d := s.conn.driver
+	d.Lock()
+	defer d.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "SELECT EXISTS"):
+		_, ok := d.tables[args[0].(string)]
+		return &fakeRows{values: [][]driver.Value{{ok}}}, nil
+	case strings.HasPrefix(s.query, "SELECT pg_total_relation_size"):
+		size := 0
+		for _, v := range d.tables[strings.Trim(args[0].(string), `"`)] {
+			size += len(v)
+		}
+		return &fakeRows{values: [][]driver.Value{{int64(size)}}}, nil
+	case strings.HasPrefix(s.query, "SELECT value FROM"):
+		table := tableOf(s.query)
+		if _, ok := d.tables[table]; !ok {
+			return nil, fmt.Errorf("relation %q does not exist", table)
+		}
+		if v, ok := s.conn.pending[table][args[0].(string)]; ok {
+			if v == nil {
+				return &fakeRows{}, nil
+			}
+			return &fakeRows{values: [][]driver.Value{{v}}}, nil
+		}
+		if v, ok := d.tables[table][args[0].(string)]; ok {
+			return &fakeRows{values: [][]driver.Value{{v}}}, nil
+		}
+		return &fakeRows{}, nil
+	case strings.HasPrefix(s.query, "SELECT key, value FROM"):
+		return s.scan(args)
+	default:
+		return nil, errors.New("unsupported query: " + s.query)
+	}
+}
+
+// scan selects the rows with keys from args[0] and after args[1] in key order, before args[2] if the query has an
+// upper bound, limited to the last arg rows unless nil. The caller must hold the lock.
+func (s *fakeStmt) scan(args []driver.Value) (driver.Rows, error) {
+	d := s.conn.driver
+	table := tableOf(s.query)
+	if _, ok := d.tables[table]; !ok {
+		return nil, fmt.Errorf("relation %q does not exist", table)
+	}
+	from, cursor, end := args[0].(string), args[1].(string), ""
+	if len(args) == 4 {
+		end = args[2].(string)
+	}
+	values := make(map[string][]byte)
+	for k, v := range d.tables[table] {
+		values[k] = v
+	}
+	for k, v := range s.conn.pending[table] {
+		values[k] = v
+	}
+	keys := make([]string, 0)
+	for k, v := range values {
+		if v != nil && k >= from && k > cursor && (end == "" || k < end) {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	if limit, ok := args[len(args)-1].(int64); ok && int(limit) < len(keys) {
+		keys = keys[:limit]
+	}
+	rows := &fakeRows{columns: []string{"key", "value"}}
+	for _, k := range keys {
+		rows.values = append(rows.values, []driver.Value{k, values[k]})
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	columns []string // defaults to value
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if r.columns == nil {
+		return []string{"value"}
+	}
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// tableOf returns the first quoted identifier of the query
+func tableOf(query string) string {
+	parts := strings.SplitN(query, `"`, 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[1]
+}
@@ -2,46 +2,280 @@ package pgkv
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"unicode/utf8"
 
 	"github.com/openyard/eventstore/internal/app/kvstore"
 )
 
-var _ kvstore.KeyValueStore = (*PostgresKVS)(nil)
+var (
+	_           kvstore.KeyValueStore   = (*PostgresKVS)(nil)
+	_           kvstore.Compactor       = (*PostgresKVS)(nil)
+	_           kvstore.BucketCompactor = (*PostgresKVS)(nil)
+	validBucket                         = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+// statements executed per bucket, the table of the bucket is inserted via fmt.Sprintf
+const (
+	createTableStmt = `CREATE TABLE IF NOT EXISTS %s (key TEXT COLLATE "C" PRIMARY KEY, value BYTEA NOT NULL)`
+	collateKeyStmt  = `ALTER TABLE %s ALTER COLUMN key TYPE TEXT COLLATE "C"` // migrates tables created with the default collation
+	tableExistsStmt = `SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1)`
+	upsertStmt      = `INSERT INTO %s (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value`
+	selectStmt      = `SELECT value FROM %s WHERE key = $1`
+	deleteStmt      = `DELETE FROM %s WHERE key = $1`
+	scanStmt        = `SELECT key, value FROM %s WHERE key >= $1 AND key > $2 ORDER BY key LIMIT $3`
+	scanRangeStmt   = `SELECT key, value FROM %s WHERE key >= $1 AND key > $2 AND key < $3 ORDER BY key LIMIT $4`
+	vacuumStmt      = `VACUUM %s`
+	tableSizeStmt   = `SELECT pg_total_relation_size($1)`
+	tablePrefix     = "kvs" // buckets are named like _index, so the table of the bucket is kvs_index
+)
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
 
+// PostgresKVS stores each bucket in its own table with a text key and a bytea value. Operations on the store run on
+// the connection pool, so they never join a transaction started with WithTx.
 type PostgresKVS struct {
-	db *sql.DB
+	db      *sql.DB
+	buckets []string
+}
+
+// pgTx runs the operations of a transaction started with WithTx within the database transaction
+type pgTx struct {
+	tx *sql.Tx
 }
 
 func NewPostgresKVS(db *sql.DB, buckets ...string) *PostgresKVS {
 	assertBuckets(db, buckets...)
-	return &PostgresKVS{db: db}
+	return &PostgresKVS{db: db, buckets: buckets}
 }
 
 func (p *PostgresKVS) AssertBucket(bucket string) error {
-	//TODO implement me
-	panic("implement me")
+	table, err := tableName(bucket)
+	if err != nil {
+		return err
+	}
+	var exists bool
+	if err := p.db.QueryRow(tableExistsStmt, table).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("bucket (%s) not found", bucket)
+	}
+	return nil
 }
 
 func (p *PostgresKVS) Put(bucket, key string, value []byte) error {
-	//TODO implement me
-	panic("implement me")
+	return put(p.db, bucket, key, value)
 }
 
 func (p *PostgresKVS) Get(bucket, key string) ([]byte, error) {
-	//TODO implement me
-	panic("implement me")
+	return get(p.db, bucket, key)
+}
+
+func (p *PostgresKVS) Delete(bucket, key string) error {
+	return remove(p.db, bucket, key)
 }
 
-func (p *PostgresKVS) WithTx(fn ...func() error) error {
-	panic("implement me")
-	//TODO implement me
+// Scan returns the keys of the bucket in ascending byte order, see kvstore.KeyValueStore
+func (p *PostgresKVS) Scan(bucket, prefix, cursor string, limit int) ([]kvstore.KeyValue, string, error) {
+	return scan(p.db, bucket, prefix, cursor, limit)
 }
 
+// WithTx runs all functions within one database transaction. The transaction is committed if all functions succeed
+// and rolled back otherwise.
+func (p *PostgresKVS) WithTx(fn ...func(tx kvstore.ReadWriter) error) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	handle := &pgTx{tx: tx}
+	for _, f := range fn {
+		if err := f(handle); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("[ERROR]\t %T.WithTx - rollback failed: %s", p, rbErr)
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Compact vacuums the tables of all buckets. Vacuuming makes the storage of deleted rows available for reuse, but
+// only returns the storage of empty pages at the end of a table to the operating system.
+func (p *PostgresKVS) Compact() (int64, error) {
+	var freed int64
+	for _, bucket := range p.buckets {
+		n, err := p.CompactBucket(bucket)
+		if err != nil {
+			return freed, err
+		}
+		freed += n
+	}
+	return freed, nil
+}
+
+// CompactBucket vacuums the table of the bucket, see Compact
+func (p *PostgresKVS) CompactBucket(bucket string) (int64, error) {
+	table, err := tableName(bucket)
+	if err != nil {
+		return 0, err
+	}
+	var before, after int64
+	if err := p.db.QueryRow(tableSizeStmt, quote(table)).Scan(&before); err != nil {
+		return 0, err
+	}
+	if _, err := p.db.Exec(fmt.Sprintf(vacuumStmt, quote(table))); err != nil {
+		return 0, err
+	}
+	if err := p.db.QueryRow(tableSizeStmt, quote(table)).Scan(&after); err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// Rollback is a no-op since WithTx already rolls back failed transactions
 func (p *PostgresKVS) Rollback() {
-	//TODO implement me
-	panic("implement me")
+	// empty on purpose
+}
+
+func (t *pgTx) Put(bucket, key string, value []byte) error {
+	return put(t.tx, bucket, key, value)
+}
+
+func (t *pgTx) Get(bucket, key string) ([]byte, error) {
+	return get(t.tx, bucket, key)
+}
+
+func (t *pgTx) Delete(bucket, key string) error {
+	return remove(t.tx, bucket, key)
+}
+
+func (t *pgTx) Scan(bucket, prefix, cursor string, limit int) ([]kvstore.KeyValue, string, error) {
+	return scan(t.tx, bucket, prefix, cursor, limit)
+}
+
+func put(q querier, bucket, key string, value []byte) error {
+	table, err := tableName(bucket)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	_, err = q.Exec(fmt.Sprintf(upsertStmt, quote(table)), key, value)
+	return err
+}
+
+func get(q querier, bucket, key string) ([]byte, error) {
+	table, err := tableName(bucket)
+	if err != nil {
+		return nil, err
+	}
+	var value []byte
+	err = q.QueryRow(fmt.Sprintf(selectStmt, quote(table)), key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("key (%s:%s) %w", bucket, key, kvstore.ErrNotFound)
+	}
+	return value, err
+}
+
+func remove(q querier, bucket, key string) error {
+	table, err := tableName(bucket)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(fmt.Sprintf(deleteStmt, quote(table)), key)
+	return err
+}
+
+func scan(q querier, bucket, prefix, cursor string, limit int) ([]kvstore.KeyValue, string, error) {
+	table, err := tableName(bucket)
+	if err != nil {
+		return nil, "", err
+	}
+	var rowLimit sql.NullInt64 // NULL selects all rows
+	if limit > 0 {
+		rowLimit = sql.NullInt64{Int64: int64(limit) + 1, Valid: true} // the additional row tells if more keys follow
+	}
+	// the keys with the prefix form a range of the primary key index, which orders them by bytes
+	var rows *sql.Rows
+	if end, ok := upperBound(prefix); ok {
+		rows, err = q.Query(fmt.Sprintf(scanRangeStmt, quote(table)), prefix, cursor, end, rowLimit)
+	} else {
+		rows, err = q.Query(fmt.Sprintf(scanStmt, quote(table)), prefix, cursor, rowLimit)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	result := make([]kvstore.KeyValue, 0)
+	for rows.Next() {
+		var kv kvstore.KeyValue
+		if err := rows.Scan(&kv.Key, &kv.Value); err != nil {
+			return nil, "", err
+		}
+		result = append(result, kv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if limit > 0 && len(result) > limit {
+		result, next = result[:limit], result[limit-1].Key
+	}
+	return result, next, nil
+}
+
+// upperBound returns the least key greater than all keys with the prefix, false if there is none. The last rune of
+// the prefix is incremented instead of its last byte, so the bound stays valid UTF-8.
+func upperBound(prefix string) (string, bool) {
+	for prefix != "" {
+		r, size := utf8.DecodeLastRuneInString(prefix)
+		prefix = prefix[:len(prefix)-size]
+		switch {
+		case r == utf8.MaxRune || r == utf8.RuneError && size == 1:
+			continue
+		case r == 0xd7ff: // the surrogates aren't valid runes
+			return prefix + string(rune(0xe000)), true
+		default:
+			return prefix + string(r+1), true
+		}
+	}
+	return "", false
 }
 
 func assertBuckets(db *sql.DB, buckets ...string) {
+	defer log.Printf("assert buckets: %+v", buckets)
+	for _, bucket := range buckets {
+		table, err := tableName(bucket)
+		if err != nil {
+			log.Panic(err)
+		}
+		if _, err := db.Exec(fmt.Sprintf(createTableStmt, quote(table))); err != nil {
+			log.Panicf("create table for bucket (%s) failed: %s", bucket, err)
+		}
+		if _, err := db.Exec(fmt.Sprintf(collateKeyStmt, quote(table))); err != nil {
+			log.Panicf("collate keys of bucket (%s) failed: %s", bucket, err)
+		}
+	}
+}
+
+// tableName returns the name of the table storing the bucket
+func tableName(bucket string) (string, error) {
+	if !validBucket.MatchString(bucket) {
+		return "", fmt.Errorf("invalid bucket name (%s)", bucket)
+	}
+	return tablePrefix + bucket, nil
+}
 
+func quote(identifier string) string {
+	return `"` + identifier + `"`
 }
@@ -0,0 +1,72 @@
+package pgkv_test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	_ "github.com/lib/pq"
+	"github.com/openyard/eventstore/internal/app/kvstore"
+	"github.com/openyard/eventstore/pkg/kvstore/pgkv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dsnEnv names the environment variable with the DSN of the postgres database the integration tests run against,
+// the tests are skipped if it is not set
+const dsnEnv = "EVENTSTORE_TEST_POSTGRES_DSN"
+
+func TestPostgresKVS_Integration(t *testing.T) {
+	dsn := os.Getenv(dsnEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", dsnEnv)
+	}
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	buckets := []string{"_it_index", "_it_subscriptions"}
+	t.Cleanup(func() {
+		for _, bucket := range buckets {
+			_, _ = db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "kvs%s"`, bucket))
+		}
+	})
+	sut := pgkv.NewPostgresKVS(db, buckets...)
+
+	t.Run("isolation", func(t *testing.T) {
+		assertTxIsolated(t, sut, buckets[0], buckets[1])
+	})
+
+	t.Run("concurrent reads", func(t *testing.T) {
+		require.NoError(t, sut.Put(buckets[0], "stream-2", []byte{1}))
+		var wg sync.WaitGroup
+		done := make(chan struct{})
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					v, err := sut.Get(buckets[0], "stream-2")
+					assert.NoError(t, err)
+					assert.Equal(t, []byte{1}, v, "uncommitted write visible outside the transaction")
+				}
+			}()
+		}
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, sut.WithTx(func(tx kvstore.ReadWriter) error {
+				if err := tx.Put(buckets[0], "stream-2", []byte{2}); err != nil {
+					return err
+				}
+				return tx.Put(buckets[0], "stream-2", []byte{1})
+			}))
+		}
+		close(done)
+		wg.Wait()
+	})
+}
@@ -0,0 +1,193 @@
+package pgkv_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/openyard/eventstore/internal/app/kvstore"
+	"github.com/openyard/eventstore/pkg/kvstore/pgkv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPostgresKVS(t *testing.T) {
+	sut := pgkv.NewPostgresKVS(openFakeDB("pgkv-new"), "_index", "_meta", "_content")
+	assert.NoError(t, sut.AssertBucket("_index"))
+	assert.NoError(t, sut.AssertBucket("_meta"))
+	assert.NoError(t, sut.AssertBucket("_content"))
+
+	v, err := sut.Get("_index", "foo")
+	assert.Empty(t, v)
+	assert.EqualError(t, err, "key (_index:foo) not found")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
+
+	assert.Equal(t, fmt.Errorf("bucket (foo) not found"), sut.AssertBucket("foo"))
+	assert.Error(t, sut.Put("foo", "bar", []byte("baz")))
+	assert.Equal(t, fmt.Errorf("invalid bucket name (foo; DROP TABLE kvs_index)"),
+		sut.Put("foo; DROP TABLE kvs_index", "bar", []byte("baz")))
+
+	assert.NoError(t, sut.Put("_index", "foo", []byte("bar")))
+	assert.NoError(t, sut.Put("_index", "foo", []byte("baz")))
+	v, err = sut.Get("_index", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "baz", string(v))
+}
+
+func TestPostgresKVS_WithTx(t *testing.T) {
+	sut := pgkv.NewPostgresKVS(openFakeDB("pgkv-tx"), "_index", "_content")
+
+	assert.NoError(t, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		if err := tx.Put("_index", "stream-1", []byte{1}); err != nil {
+			return err
+		}
+		v, err := tx.Get("_index", "stream-1")
+		assert.Equal(t, []byte{1}, v)
+		return err
+	}, func(tx kvstore.ReadWriter) error {
+		return tx.Put("_content", "stream-1", []byte("event"))
+	}))
+	v, err := sut.Get("_content", "stream-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "event", string(v))
+
+	failure := errors.New("failure")
+	assert.Equal(t, failure, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		return tx.Put("_index", "stream-2", []byte{2})
+	}, func(tx kvstore.ReadWriter) error {
+		return failure
+	}))
+	sut.Rollback()
+	_, err = sut.Get("_index", "stream-2")
+	assert.EqualError(t, err, "key (_index:stream-2) not found")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
+	v, err = sut.Get("_index", "stream-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1}, v)
+}
+
+func TestPostgresKVS_Delete(t *testing.T) {
+	sut := pgkv.NewPostgresKVS(openFakeDB("pgkv-delete"), "_index")
+	assert.NoError(t, sut.Put("_index", "stream-1", []byte{1}))
+	assert.NoError(t, sut.Put("_index", "stream-2", []byte{2}))
+	assert.NoError(t, sut.Delete("_index", "stream-1"))
+	assert.NoError(t, sut.Delete("_index", "missing"))
+	_, err := sut.Get("_index", "stream-1")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
+
+	failure := errors.New("failure")
+	assert.Equal(t, failure, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		return tx.Delete("_index", "stream-2")
+	}, func(tx kvstore.ReadWriter) error {
+		return failure
+	}))
+	v, err := sut.Get("_index", "stream-2")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2}, v)
+
+	assert.NoError(t, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		if err := tx.Delete("_index", "stream-2"); err != nil {
+			return err
+		}
+		_, err := tx.Get("_index", "stream-2")
+		assert.ErrorIs(t, err, kvstore.ErrNotFound)
+		return nil
+	}))
+	_, err = sut.Get("_index", "stream-2")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
+}
+
+func TestPostgresKVS_WithTxIsIsolated(t *testing.T) {
+	assertTxIsolated(t, pgkv.NewPostgresKVS(openFakeDB("pgkv-isolated"), "_index", "_subscriptions"),
+		"_index", "_subscriptions")
+}
+
+// assertTxIsolated asserts that operations on the store neither see the writes of a running transaction nor join it
+func assertTxIsolated(t *testing.T, sut *pgkv.PostgresKVS, txBucket, bucket string) {
+	failure := errors.New("failure")
+	assert.Equal(t, failure, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		if err := tx.Put(txBucket, "stream-1", []byte{1}); err != nil {
+			return err
+		}
+		_, err := sut.Get(txBucket, "stream-1")
+		assert.ErrorIs(t, err, kvstore.ErrNotFound, "uncommitted write visible outside the transaction")
+		kvs, _, err := sut.Scan(txBucket, "", "", 0)
+		assert.NoError(t, err)
+		assert.Empty(t, kvs, "uncommitted write visible to scans outside the transaction")
+		assert.NoError(t, sut.Put(bucket, "sub-1", []byte{2}))
+		return failure
+	}))
+	v, err := sut.Get(bucket, "sub-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2}, v)
+	_, err = sut.Get(txBucket, "stream-1")
+	assert.ErrorIs(t, err, kvstore.ErrNotFound)
+}
+
+func TestPostgresKVS_Compact(t *testing.T) {
+	sut := pgkv.NewPostgresKVS(openFakeDB("pgkv-compact"), "_index", "_content")
+	assert.NoError(t, sut.Put("_content", "stream-1", []byte("event")))
+	freed, err := sut.Compact()
+	assert.NoError(t, err)
+	assert.Zero(t, freed)
+}
+
+func TestPostgresKVS_CompactBucket(t *testing.T) {
+	sut := pgkv.NewPostgresKVS(openFakeDB("pgkv-compact-bucket"), "_keys")
+	assert.NoError(t, sut.Put("_keys", "aggregate-1", []byte{}))
+	freed, err := sut.CompactBucket("_keys")
+	assert.NoError(t, err)
+	assert.Zero(t, freed)
+	_, err = sut.CompactBucket("_content")
+	assert.Error(t, err)
+	_, err = sut.CompactBucket("foo; DROP TABLE kvs_keys")
+	assert.Error(t, err)
+}
+
+func TestPostgresKVS_Scan(t *testing.T) {
+	sut := pgkv.NewPostgresKVS(openFakeDB("pgkv-scan"), "_content")
+	for _, key := range []string{"stream-2/02", "stream-1/01", "other", "stream-1/02", "stream-1/03", "stream-10/01"} {
+		assert.NoError(t, sut.Put("_content", key, []byte(key)))
+	}
+
+	kvs, next, err := sut.Scan("_content", "stream-1/", "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []kvstore.KeyValue{{Key: "stream-1/01", Value: []byte("stream-1/01")}, {Key: "stream-1/02", Value: []byte("stream-1/02")}}, kvs)
+	assert.Equal(t, "stream-1/02", next)
+	kvs, next, err = sut.Scan("_content", "stream-1/", next, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []kvstore.KeyValue{{Key: "stream-1/03", Value: []byte("stream-1/03")}}, kvs)
+	assert.Empty(t, next)
+	for prefix, want := range map[string][]string{
+		"":                  {"other", "stream-1/01", "stream-1/02", "stream-1/03", "stream-10/01", "stream-2/02"},
+		"stream-1":          {"stream-1/01", "stream-1/02", "stream-1/03", "stream-10/01"},
+		"stream-\U0010FFFF": nil,
+		"stream-\ud7ff":     nil,
+	} {
+		kvs, _, err := sut.Scan("_content", prefix, "", 0)
+		assert.NoError(t, err)
+		var keys []string
+		for _, kv := range kvs {
+			keys = append(keys, kv.Key)
+		}
+		assert.Equal(t, want, keys, "prefix %q", prefix)
+	}
+
+	assert.NoError(t, sut.WithTx(func(tx kvstore.ReadWriter) error {
+		if err := tx.Delete("_content", "stream-1/01"); err != nil {
+			return err
+		}
+		if err := tx.Put("_content", "stream-1/04", []byte("new")); err != nil {
+			return err
+		}
+		kvs, next, err := tx.Scan("_content", "", "other", 0)
+		keys := make([]string, 0, len(kvs))
+		for _, kv := range kvs {
+			keys = append(keys, kv.Key)
+		}
+		assert.Equal(t, []string{"stream-1/02", "stream-1/03", "stream-1/04", "stream-10/01", "stream-2/02"}, keys)
+		assert.Empty(t, next)
+		return err
+	}))
+	_, _, err = sut.Scan("foo", "", "", 0)
+	assert.Error(t, err)
+}